@@ -9,8 +9,10 @@ import (
 	"time"
 	"yp-diploma/internal/app/config"
 	"yp-diploma/internal/app/endpoint"
+	"yp-diploma/internal/app/model"
 	"yp-diploma/internal/app/mware"
 	"yp-diploma/internal/app/repository"
+	"yp-diploma/internal/app/scheduler"
 	"yp-diploma/internal/app/service"
 
 	"github.com/go-chi/chi/middleware"
@@ -23,6 +25,8 @@ type App struct {
 	e  *endpoint.Endpoint
 	db *repository.Repository
 	lh *mware.LoginHandler
+	ih *mware.IdempotencyHandler
+	sc *scheduler.Scheduler
 	r  chi.Router
 }
 
@@ -32,7 +36,10 @@ func New() *App {
 	a.db = repository.New()
 	a.s = service.New(a.db, a.c)
 	a.e = endpoint.New(a.c, a.s)
-	a.lh = mware.NewLoginHandler(config.CookieName, a.s)
+	a.sc = scheduler.New()
+	a.s.RegisterHousekeeping(a.sc)
+	a.lh = mware.NewLoginHandler(config.CookieName, a.c, a.s)
+	a.ih = mware.NewIdempotencyHandler(a.s)
 	a.r = chi.NewRouter()
 
 	a.r.Use(middleware.RealIP)
@@ -44,15 +51,67 @@ func New() *App {
 
 	a.r.Post("/api/user/register", a.e.Register)
 	a.r.Post("/api/user/login", a.e.Login)
+	a.r.Post("/api/user/login/2fa", a.e.LoginSecondFactor)
+	a.r.Post("/api/user/token/refresh", a.e.RefreshToken)
+	a.r.Get("/api/user/profile/verify", a.e.VerifyEmail)
+	a.r.Post("/api/user/password/forgot", a.e.ForgotPassword)
+	a.r.Post("/api/user/password/reset", a.e.ResetPassword)
+	if a.c.OIDCIssuer != "" {
+		a.r.Get("/api/user/oidc/login", a.e.OIDCLogin)
+		a.r.Get("/api/user/oidc/callback", a.e.OIDCCallback)
+	}
 
 	a.r.Group(func(r chi.Router) {
 		r.Use(a.lh.AuthUser)
+		r.Use(a.lh.CSRF)
 		r.Get("/", a.e.Info)
-		r.Post("/api/user/orders", a.e.NewOrder)
-		r.Get("/api/user/orders", a.e.UserOrders)
-		r.Get("/api/user/balance", a.e.UserBalance)
-		r.Post("/api/user/balance/withdraw", a.e.NewWithdraw)
-		r.Get("/api/user/withdrawals", a.e.UserWithdraws)
+		r.With(mware.RequireScope(model.ScopeOrdersWrite), a.ih.Idempotent).Post("/api/user/orders", a.e.NewOrder)
+		r.With(mware.RequireScope(model.ScopeOrdersRead)).Get("/api/user/orders", a.e.UserOrders)
+		r.With(mware.RequireScope(model.ScopeBalanceRead)).Get("/api/user/balance", a.e.UserBalance)
+		r.With(mware.RequireScope(model.ScopeWithdraw), a.ih.Idempotent).Post("/api/user/balance/withdraw", a.e.NewWithdraw)
+		r.With(mware.RequireScope(model.ScopeBalanceRead)).Get("/api/user/withdrawals", a.e.UserWithdraws)
+		r.With(mware.RequireScope(model.ScopeWithdraw)).Post("/api/user/withdrawals/{order}/cancel", a.e.CancelWithdraw)
+		r.With(mware.RequireScope(model.ScopeBalanceRead)).Get("/api/user/balance/holds", a.e.UserHolds)
+		r.With(mware.RequireScope(model.ScopeWithdraw), a.ih.Idempotent).Post("/api/user/balance/holds", a.e.NewHold)
+		r.With(mware.RequireScope(model.ScopeWithdraw)).Post("/api/user/balance/holds/{id}/capture", a.e.CaptureHold)
+		r.With(mware.RequireScope(model.ScopeWithdraw)).Post("/api/user/balance/holds/{id}/release", a.e.ReleaseHold)
+		r.With(mware.RequireScope(model.ScopeTransfer), a.ih.Idempotent).Post("/api/user/balance/transfer", a.e.NewTransfer)
+		r.With(mware.RequireScope(model.ScopeBalanceRead)).Get("/api/user/transfers", a.e.UserTransfers)
+		r.With(mware.RequireScope(model.ScopeBalanceRead)).Get("/api/user/transactions", a.e.UserTransactions)
+		r.With(mware.RequireScope(model.ScopeBalanceRead)).Get("/api/user/statements/{month}", a.e.UserStatement)
+
+		// управление учетной записью доступно только с сессией пользователя
+		r.Group(func(r chi.Router) {
+			r.Use(mware.RequireScope(model.ScopeAccount))
+			r.Post("/api/user/logout", a.e.Logout)
+			r.Post("/api/user/password", a.e.ChangePassword)
+			r.Post("/api/user/2fa/enroll", a.e.EnrollTOTP)
+			r.Post("/api/user/2fa/confirm", a.e.ConfirmTOTP)
+			r.Post("/api/user/2fa/disable", a.e.DisableTOTP)
+			r.Post("/api/user/apikeys", a.e.NewAPIKey)
+			r.Get("/api/user/apikeys", a.e.UserAPIKeys)
+			r.Delete("/api/user/apikeys/{id}", a.e.DeleteAPIKey)
+			r.Get("/api/user/export", a.e.ExportAccount)
+			r.Delete("/api/user", a.e.CloseAccount)
+			r.Get("/api/user/profile", a.e.UserProfile)
+			r.Patch("/api/user/profile", a.e.UpdateProfile)
+		})
+	})
+
+	a.r.Route("/api/admin", func(r chi.Router) {
+		r.Use(a.lh.AuthUser)
+		r.Use(a.lh.CSRF)
+		r.Use(mware.RequireScope(model.ScopeAccount))
+		r.Use(a.lh.RequireAdmin)
+		r.Get("/users", a.e.AdminSearchUsers)
+		r.Get("/users/{id}/orders", a.e.AdminUserOrders)
+		r.Get("/users/{id}/withdrawals", a.e.AdminUserWithdraws)
+		r.Get("/users/{id}/balance", a.e.AdminUserBalance)
+		r.Post("/users/{id}/lock", a.e.AdminLockUser)
+		r.Post("/users/{id}/unlock", a.e.AdminUnlockUser)
+		r.With(a.ih.Idempotent).Post("/users/{id}/adjustments", a.e.AdminAdjustBalance)
+		r.Post("/withdrawals/{id}/refund", a.e.AdminRefundWithdraw)
+		r.Get("/dispatcher", a.e.AdminDispatcher)
 	})
 	return a
 }
@@ -63,11 +122,24 @@ func (a *App) Run() error {
 	if err != nil {
 		return err
 	}
+	legacyUntil, err := a.s.LegacyTokenCutoff(context.Background())
+	if err != nil {
+		return err
+	}
+	a.lh.SetLegacyUntil(legacyUntil)
+	err = a.s.PromoteAdmins(context.Background())
+	if err != nil {
+		return err
+	}
 	err = a.s.ProceedUndoneOrders(context.Background())
 	if err != nil {
 		return err
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	a.sc.Start(ctx)
+	a.s.ListenRevocations(ctx)
+
 	server := newServer(a.c.Listen, a.r)
 	go func() {
 		log.Println("service listening at:", a.c.Listen)
@@ -78,6 +150,8 @@ func (a *App) Run() error {
 		log.Println("server gracefully shut down")
 	}()
 	waitForShutDown(server)
+	cancel()
+	a.sc.Wait()
 
 	return nil
 }
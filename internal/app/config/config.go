@@ -3,7 +3,10 @@ package config
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v7"
@@ -13,16 +16,104 @@ type Config struct {
 	Listen        string `env:"RUN_ADDRESS"`
 	PgConnString  string `env:"DATABASE_URI"`
 	AccrualSystem string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	// до какого момента принимаются токены старого формата: LEGACY_TOKEN_UNTIL (RFC 3339)
+	// или LEGACY_TOKEN_GRACE после первого запуска с этой БД (момент сохраняется в app_settings)
+	LegacyTokenUntil time.Time     `env:"LEGACY_TOKEN_UNTIL"`
+	LegacyTokenGrace time.Duration `env:"LEGACY_TOKEN_GRACE" envDefault:"168h"`
+	// сессия живет AccessTokenTTL с последнего обращения, но не дольше AccessTokenMaxAge
+	AccessTokenTTL    time.Duration `env:"ACCESS_TOKEN_TTL" envDefault:"15m"`
+	AccessTokenMaxAge time.Duration `env:"ACCESS_TOKEN_MAX_AGE" envDefault:"12h"`
+	RefreshTokenTTL   time.Duration `env:"REFRESH_TOKEN_TTL" envDefault:"720h"`
+	// защита от подбора пароля: после LoginFreeAttempts неудач каждая следующая попытка
+	// возможна не раньше чем через LoginDelay*2^n, после LoginMaxFailures (LoginMaxFailuresIP для адреса)
+	// вход блокируется на LoginLockout. Счетчик сбрасывается, если неудач не было LoginFailureWindow.
+	LoginFreeAttempts  int           `env:"LOGIN_FREE_ATTEMPTS" envDefault:"3"`
+	LoginDelay         time.Duration `env:"LOGIN_DELAY" envDefault:"1s"`
+	LoginMaxFailures   int           `env:"LOGIN_MAX_FAILURES" envDefault:"10"`
+	LoginMaxFailuresIP int           `env:"LOGIN_MAX_FAILURES_IP" envDefault:"50"`
+	LoginLockout       time.Duration `env:"LOGIN_LOCKOUT" envDefault:"15m"`
+	LoginFailureWindow time.Duration `env:"LOGIN_FAILURE_WINDOW" envDefault:"1h"`
+	// требования к паролю: минимальная длина и файл со списком запрещенных паролей (по одному в строке)
+	PasswordMinLen    int    `env:"PASSWORD_MIN_LEN" envDefault:"8"`
+	PasswordBlocklist string `env:"PASSWORD_BLOCKLIST"`
+	// имя сервиса в приложении-аутентификаторе
+	TOTPIssuer string `env:"TOTP_ISSUER" envDefault:"Gophermart"`
+	// логины пользователей, которым при запуске назначается роль администратора
+	AdminUsers []string `env:"ADMIN_USERS" envSeparator:","`
+	// интервалы заданий обслуживания (0 - задание отключено) и сроки хранения записей
+	SessionPurgeInterval  time.Duration `env:"SESSION_PURGE_INTERVAL" envDefault:"10m"`
+	AttemptsPurgeInterval time.Duration `env:"ATTEMPTS_PURGE_INTERVAL" envDefault:"1h"`
+	AttemptsRetention     time.Duration `env:"ATTEMPTS_RETENTION" envDefault:"24h"`
+	EventsVacuumInterval  time.Duration `env:"EVENTS_VACUUM_INTERVAL" envDefault:"24h"`
+	EventsRetention       time.Duration `env:"EVENTS_RETENTION" envDefault:"8760h"`
+	// кеш проверенных сессий (0 - кеш отключен)
+	SessionCacheSize int           `env:"SESSION_CACHE_SIZE" envDefault:"10000"`
+	SessionCacheTTL  time.Duration `env:"SESSION_CACHE_TTL" envDefault:"30s"`
+	// атрибуты cookie сессии и проверка CSRF-токена для запросов с cookie
+	CookieSecure   bool   `env:"COOKIE_SECURE" envDefault:"false"`
+	CookieSameSite string `env:"COOKIE_SAMESITE" envDefault:"Lax"`
+	CookieDomain   string `env:"COOKIE_DOMAIN"`
+	CSRFProtection bool   `env:"CSRF_PROTECTION" envDefault:"true"`
+	// адрес сервиса для ссылок в письмах, по умолчанию http://<RUN_ADDRESS>
+	PublicURL string `env:"PUBLIC_URL"`
+	// отправка писем: MAIL_TRANSPORT=smtp или file (письма пишутся в MAIL_DIR)
+	MailTransport  string        `env:"MAIL_TRANSPORT" envDefault:"file"`
+	MailDir        string        `env:"MAIL_DIR" envDefault:"mail"`
+	MailFrom       string        `env:"MAIL_FROM" envDefault:"gophermart@localhost"`
+	SMTPAddr       string        `env:"SMTP_ADDR"`
+	SMTPUser       string        `env:"SMTP_USER"`
+	SMTPPassword   string        `env:"SMTP_PASSWORD"`
+	EmailVerifyTTL time.Duration `env:"EMAIL_VERIFY_TTL" envDefault:"24h"`
+	// сброс пароля: срок действия токена из письма и число запросов на адрес (на клиента)
+	// за LoginFailureWindow, после которого запросы блокируются на LoginLockout
+	PasswordResetTTL   time.Duration `env:"PASSWORD_RESET_TTL" envDefault:"1h"`
+	PasswordResetMax   int           `env:"PASSWORD_RESET_MAX" envDefault:"3"`
+	PasswordResetMaxIP int           `env:"PASSWORD_RESET_MAX_IP" envDefault:"20"`
+	// вход через OpenID Connect, включается заданием OIDC_ISSUER. Адрес возврата
+	// по умолчанию <PUBLIC_URL>/api/user/oidc/callback
+	OIDCIssuer       string   `env:"OIDC_ISSUER"`
+	OIDCClientID     string   `env:"OIDC_CLIENT_ID"`
+	OIDCClientSecret string   `env:"OIDC_CLIENT_SECRET"`
+	OIDCRedirectURL  string   `env:"OIDC_REDIRECT_URL"`
+	OIDCScopes       []string `env:"OIDC_SCOPES" envSeparator:"," envDefault:"openid,profile,email"`
+	// сколько хранятся ответы на запросы с заголовком Idempotency-Key
+	IdempotencyTTL time.Duration `env:"IDEMPOTENCY_TTL" envDefault:"24h"`
+	// через сколько незавершенный запрос перестает занимать ключ (например, после падения процесса)
+	IdempotencyLease time.Duration `env:"IDEMPOTENCY_LEASE" envDefault:"1m"`
+	// сколько после списания пользователь может его отменить (0 - отмена запрещена)
+	WithdrawCancelWindow time.Duration `env:"WITHDRAW_CANCEL_WINDOW" envDefault:"15m"`
+	// срок резерва баллов и период освобождения просроченных резервов
+	HoldTTL             time.Duration `env:"HOLD_TTL" envDefault:"30m"`
+	HoldReleaseInterval time.Duration `env:"HOLD_RELEASE_INTERVAL" envDefault:"1m"`
+	// срок жизни начисленных баллов (0 - баллы не сгорают), за сколько до сгорания
+	// баллы показываются в ответе о балансе и период задания сгорания
+	PointsTTL            time.Duration `env:"POINTS_TTL" envDefault:"0"`
+	PointsExpiryNotice   time.Duration `env:"POINTS_EXPIRY_NOTICE" envDefault:"720h"`
+	PointsExpiryInterval time.Duration `env:"POINTS_EXPIRY_INTERVAL" envDefault:"1h"`
+	// сколько баллов пользователь может перевести другим за последние сутки (0 - без ограничения)
+	TransferDailyLimit int `env:"TRANSFER_DAILY_LIMIT" envDefault:"1000"`
 }
 
 type ctxKey string
 
 const (
 	CookieName               string        = "LOGININFO"
+	RefreshCookieName        string        = "REFRESHINFO"
+	CSRFCookieName           string        = "CSRF-TOKEN"
+	CSRFHeader               string        = "X-CSRF-Token"
 	PassCiph                 string        = "AF12345"
 	ContextKeyUserID         ctxKey        = ctxKey(CookieName)
-	SessionKeyDuration       time.Duration = 30 * 24 * time.Hour
+	ContextKeySessionID      ctxKey        = ctxKey("SESSIONID")
+	ContextKeyScopes         ctxKey        = ctxKey("SCOPES")
+	ContextKeyAuthMethod     ctxKey        = ctxKey("AUTHMETHOD")
+	APIKeyHeader             string        = "X-API-Key"
+	IdempotencyKeyHeader     string        = "Idempotency-Key"
 	OrdersPerMinuteToAccrual int           = 59
+	LoginChallengeDuration   time.Duration = 5 * time.Minute
+	LoginChallengeAttempts   int           = 5
+	TOTPRecoveryCodes        int           = 10
+	OIDCStateCookieName      string        = "OIDCSTATE"
+	OIDCLoginDuration        time.Duration = 10 * time.Minute
 )
 
 func New() *Config {
@@ -38,9 +129,35 @@ func New() *Config {
 	if c.Listen == "" || c.PgConnString == "" || c.AccrualSystem == "" {
 		log.Fatal("not enought parameters to work.")
 	}
+	if _, err := c.SameSite(); err != nil {
+		log.Fatal(err)
+	}
+	if c.PublicURL == "" {
+		c.PublicURL = "http://" + c.Listen
+	}
+	c.PublicURL = strings.TrimSuffix(c.PublicURL, "/")
+	if c.OIDCIssuer != "" && c.OIDCClientID == "" {
+		log.Fatal("OIDC_CLIENT_ID is required when OIDC_ISSUER is set")
+	}
+	if c.OIDCRedirectURL == "" {
+		c.OIDCRedirectURL = c.PublicURL + "/api/user/oidc/callback"
+	}
 	return c
 }
 
+func (c *Config) SameSite() (http.SameSite, error) {
+	switch strings.ToLower(c.CookieSameSite) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return http.SameSiteDefaultMode, fmt.Errorf("unsupported COOKIE_SAMESITE value: %s", c.CookieSameSite)
+	}
+}
+
 var (
 	ErrUserNameBusy          = errors.New("user name busy")
 	ErrUserInvalidPassword   = errors.New("invalid password")
@@ -51,6 +168,34 @@ var (
 	ErrOrderRegistered       = errors.New("same order registered in system")
 	ErrNoSuchOrder           = errors.New("customer does not have such an order")
 	ErrNotEnoughAccruals     = errors.New("not enough accruals")
+	ErrWithdrawOrderUsed     = errors.New("order number already used for withdrawal")
+	ErrWithdrawReversed      = errors.New("withdrawal already cancelled or refunded")
+	ErrCancelWindowExpired   = errors.New("withdrawal can no longer be cancelled")
+	ErrHoldSettled           = errors.New("hold already captured or released")
+	ErrHoldExpired           = errors.New("hold expired")
+	ErrTransferToSelf        = errors.New("cannot transfer points to yourself")
+	ErrTransferLimit         = errors.New("daily transfer limit exceeded")
 	ErrGetAccrual            = errors.New("can't get accrual information")
 	ErrUnsupportedResponse   = errors.New("accrual server return unsupported result")
+	ErrInvalidToken          = errors.New("invalid session token")
+	ErrTokenExpired          = errors.New("session token expired")
+	ErrLegacyToken           = errors.New("legacy session token no longer accepted")
+	ErrRefreshTokenReused    = errors.New("refresh token reused")
+	ErrTooManyAttempts       = errors.New("too many login attempts")
+	ErrPasswordTooShort      = errors.New("password too short")
+	ErrPasswordBlocked       = errors.New("password is too common")
+	ErrSecondFactorRequired  = errors.New("second factor required")
+	ErrTOTPEnabled           = errors.New("two-factor authentication already enabled")
+	ErrTOTPNotEnrolled       = errors.New("two-factor authentication not enrolled")
+	ErrInvalidOTP            = errors.New("invalid one-time code")
+	ErrInvalidScope          = errors.New("invalid api key scope")
+	ErrInvalidAPIKey         = errors.New("invalid api key")
+	ErrUserLocked            = errors.New("user account locked")
+	ErrNotAdmin              = errors.New("admin role required")
+	ErrInvalidEmail          = errors.New("invalid email address")
+	ErrInvalidProfile        = errors.New("invalid profile data")
+	ErrEmailBusy             = errors.New("email already in use")
+	ErrOIDCLogin             = errors.New("single sign-on failed")
+	ErrIdempotencyMismatch   = errors.New("idempotency key reused with a different request")
+	ErrIdempotencyInProgress = errors.New("request with this idempotency key is in progress")
 )
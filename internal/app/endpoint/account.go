@@ -0,0 +1,56 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+)
+
+// ExportAccount отдает все данные пользователя одним JSON-файлом.
+func (e *Endpoint) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	res, err := e.srv.ExportAccount(r.Context())
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Printf("error exporting account:\n error: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="gophermart-export.json"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalAccountExportDoc(res))
+}
+
+// CloseAccount - закрытие учетной записи {"password": "...", "code": "..."},
+// код нужен только при включенном втором факторе, пароль - только если он задан.
+func (e *Endpoint) CloseAccount(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	req := make(map[string]string, 0)
+	err = json.Unmarshal(buf, &req)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = e.srv.CloseAccount(r.Context(), req["password"], req["code"], clientIP(r))
+	if err != nil {
+		switch err {
+		case config.ErrUserInvalidPassword, config.ErrInvalidOTP:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case config.ErrTooManyAttempts:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error closing account:\n error: %s", err)
+		}
+		return
+	}
+	e.clearSession(w)
+	w.WriteHeader(http.StatusOK)
+}
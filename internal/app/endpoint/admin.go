@@ -0,0 +1,160 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (e *Endpoint) AdminSearchUsers(w http.ResponseWriter, r *http.Request) {
+	res, err := e.srv.SearchUsers(r.Context(), strings.TrimSpace(r.URL.Query().Get("q")))
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Printf("error searching users:\n error: %s", err)
+		return
+	}
+	if len(res) == 0 {
+		http.Error(w, "no data", http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalUsersDoc(res))
+}
+
+func (e *Endpoint) AdminUserOrders(w http.ResponseWriter, r *http.Request) {
+	res, err := e.srv.AdminUserOrders(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+	if len(res) == 0 {
+		http.Error(w, "no data", http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalUserOrdersDoc(res))
+}
+
+func (e *Endpoint) AdminUserWithdraws(w http.ResponseWriter, r *http.Request) {
+	res, err := e.srv.AdminUserWithdraws(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+	if len(res) == 0 {
+		http.Error(w, "no data", http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalUserWithdrawsDoc(res))
+}
+
+func (e *Endpoint) AdminUserBalance(w http.ResponseWriter, r *http.Request) {
+	res, err := e.srv.AdminUserBalance(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalUserBalanceDoc(res))
+}
+
+// AdminLockUser - блокировка учетной записи, необязательное тело {"reason": "..."}.
+func (e *Endpoint) AdminLockUser(w http.ResponseWriter, r *http.Request) {
+	err := e.srv.LockUser(r.Context(), chi.URLParam(r, "id"), readReason(r))
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *Endpoint) AdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	err := e.srv.UnlockUser(r.Context(), chi.URLParam(r, "id"), readReason(r))
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// AdminAdjustBalance - ручная корректировка баланса {"sum": 10.5, "reason": "..."}.
+func (e *Endpoint) AdminAdjustBalance(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	adj, err := model.UnmarshalAdjustmentRequest(buf)
+	if err != nil || strings.TrimSpace(adj.Reason) == "" {
+		http.Error(w, "error in request", http.StatusBadRequest)
+		return
+	}
+	err = e.srv.AdjustBalance(r.Context(), chi.URLParam(r, "id"), adj.Amount, strings.TrimSpace(adj.Reason))
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// AdminRefundWithdraw - возврат баллов по списанию с заданным id, необязательное тело {"reason": "..."}.
+func (e *Endpoint) AdminRefundWithdraw(w http.ResponseWriter, r *http.Request) {
+	err := e.srv.RefundWithdraw(r.Context(), chi.URLParam(r, "id"), readReason(r))
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *Endpoint) AdminDispatcher(w http.ResponseWriter, r *http.Request) {
+	res, err := e.srv.DispatcherState(r.Context())
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalDispatcherDoc(res))
+}
+
+func adminError(w http.ResponseWriter, err error) {
+	switch err {
+	case config.ErrNoSuchRecord:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case config.ErrInvalidData:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case config.ErrNotEnoughAccruals:
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+	case config.ErrWithdrawReversed:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Printf("admin action error:\n error: %s", err)
+	}
+}
+
+func readReason(r *http.Request) string {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil || len(buf) == 0 {
+		return ""
+	}
+	defer r.Body.Close()
+	req := make(map[string]string, 0)
+	if json.Unmarshal(buf, &req) != nil {
+		return ""
+	}
+	return req["reason"]
+}
@@ -0,0 +1,71 @@
+package endpoint
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// NewAPIKey - создание ключа {"name": "...", "scopes": ["orders:write"], "expires_at": "..."}.
+func (e *Endpoint) NewAPIKey(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	req, err := model.UnmarshalAPIKeyRequest(buf)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	key, secret, err := e.srv.CreateAPIKey(r.Context(), req.Name, req.Scopes, req.Expires)
+	if err != nil {
+		switch err {
+		case config.ErrInvalidScope, config.ErrInvalidData:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error creating api key:\n error: %s", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(model.MarshalNewAPIKeyDoc(key, secret))
+}
+
+func (e *Endpoint) UserAPIKeys(w http.ResponseWriter, r *http.Request) {
+	res, err := e.srv.GetAPIKeys(r.Context())
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Printf("error getting api keys:\n error: %s", err)
+		return
+	}
+	if len(res) == 0 {
+		http.Error(w, "no data", http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalAPIKeysDoc(res))
+}
+
+func (e *Endpoint) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := e.srv.DeleteAPIKey(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		switch err {
+		case config.ErrNoSuchRecord:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error deleting api key:\n error: %s", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
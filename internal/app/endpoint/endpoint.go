@@ -5,14 +5,21 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 	"yp-diploma/internal/app/config"
 	"yp-diploma/internal/app/model"
 	"yp-diploma/internal/app/service"
+	"yp-diploma/internal/app/util"
+
+	"github.com/go-chi/chi/v5"
 )
 
+// refresh-токен отправляется браузером только на эндпоинт обновления
+const refreshCookiePath = "/api/user/token"
+
 type Endpoint struct {
 	cfg *config.Config
 	srv *service.Service
@@ -45,25 +52,20 @@ func (e *Endpoint) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cryptKey, err := e.srv.RegisterUser(r.Context(), req["login"], req["password"])
+	tokens, err := e.srv.RegisterUser(r.Context(), req["login"], req["password"])
 	if err != nil {
 		switch err {
 		case config.ErrUserNameBusy:
 			http.Error(w, err.Error(), http.StatusConflict)
+		case config.ErrPasswordTooShort, config.ErrPasswordBlocked:
+			http.Error(w, err.Error(), http.StatusBadRequest)
 		default:
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			log.Printf("error creating user: %s\n error: %s", req["login"], err.Error())
 		}
 		return
 	}
-	cookie := http.Cookie{
-		Name:    config.CookieName,
-		Value:   cryptKey,
-		Path:    "/",
-		Expires: time.Now().Add(config.SessionKeyDuration),
-	}
-	http.SetCookie(w, &cookie)
-	w.WriteHeader(http.StatusOK)
+	e.writeSession(w, r, tokens)
 }
 
 func (e *Endpoint) Login(w http.ResponseWriter, r *http.Request) {
@@ -79,12 +81,24 @@ func (e *Endpoint) Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	cryptKey, err := e.srv.LoginUser(r.Context(), req["login"], req["password"])
+	tokens, err := e.srv.LoginUser(r.Context(), req["login"], req["password"], clientIP(r))
 	if err != nil {
 		switch err {
 		case config.ErrUserInvalidPassword, config.ErrNoSuchRecord:
 			http.Error(w, "Unautorized", http.StatusUnauthorized)
 			return
+		case config.ErrTooManyAttempts:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case config.ErrUserLocked:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case config.ErrSecondFactorRequired:
+			// пароль верный, сессия будет выдана после /api/user/login/2fa
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			w.Write(model.MarshalMFAChallengeDoc(tokens))
+			return
 		default:
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			log.Printf("error autorize user: %s\n error: %s", req["login"], err.Error())
@@ -92,16 +106,260 @@ func (e *Endpoint) Login(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	cookie := http.Cookie{
-		Name:    config.CookieName,
-		Value:   cryptKey,
-		Path:    "/",
-		Expires: time.Now().Add(config.SessionKeyDuration),
+	e.writeSession(w, r, tokens)
+}
+
+// LoginSecondFactor - второй шаг входа {"mfa_token": "...", "code": "..."}.
+func (e *Endpoint) LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	req := make(map[string]string, 0)
+	err = json.Unmarshal(buf, &req)
+	if err != nil || req["mfa_token"] == "" || req["code"] == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	tokens, err := e.srv.LoginSecondFactor(r.Context(), req["mfa_token"], req["code"], clientIP(r))
+	if err != nil {
+		switch err {
+		case config.ErrInvalidToken, config.ErrInvalidOTP, config.ErrTOTPNotEnrolled:
+			http.Error(w, "Unautorized", http.StatusUnauthorized)
+		case config.ErrTooManyAttempts:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		case config.ErrUserLocked:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error second factor login:\n error: %s", err)
+		}
+		return
+	}
+	e.writeSession(w, r, tokens)
+}
+
+func (e *Endpoint) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	res, err := e.srv.EnrollTOTP(r.Context())
+	if err != nil {
+		switch err {
+		case config.ErrTOTPEnabled:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error enrolling totp:\n error: %s", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalTOTPEnrollmentDoc(res))
+}
+
+// ConfirmTOTP - подтверждение подключения второго фактора {"code": "..."}.
+func (e *Endpoint) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	req := make(map[string]string, 0)
+	err = json.Unmarshal(buf, &req)
+	if err != nil || req["code"] == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = e.srv.ConfirmTOTP(r.Context(), req["code"])
+	if err != nil {
+		switch err {
+		case config.ErrInvalidOTP:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case config.ErrTOTPEnabled, config.ErrTOTPNotEnrolled:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error confirming totp:\n error: %s", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DisableTOTP - отключение второго фактора {"password": "...", "code": "..."},
+// пароль не нужен пользователям без пароля, вошедшим через OIDC.
+func (e *Endpoint) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	req := make(map[string]string, 0)
+	err = json.Unmarshal(buf, &req)
+	if err != nil || req["code"] == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = e.srv.DisableTOTP(r.Context(), req["password"], req["code"], clientIP(r))
+	if err != nil {
+		switch err {
+		case config.ErrUserInvalidPassword, config.ErrInvalidOTP:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case config.ErrTooManyAttempts:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		case config.ErrTOTPNotEnrolled:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error disabling totp:\n error: %s", err)
+		}
+		return
 	}
-	http.SetCookie(w, &cookie)
 	w.WriteHeader(http.StatusOK)
 }
 
+func (e *Endpoint) Logout(w http.ResponseWriter, r *http.Request) {
+	err := e.srv.LogoutUser(r.Context())
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Printf("error logout user:\n error: %s", err)
+		return
+	}
+	e.clearSession(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// ChangePassword - смена пароля {"current_password": "...", "new_password": "..."}.
+func (e *Endpoint) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	req := make(map[string]string, 0)
+	err = json.Unmarshal(buf, &req)
+	if err != nil || req["current_password"] == "" || req["new_password"] == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = e.srv.ChangePassword(r.Context(), req["current_password"], req["new_password"], clientIP(r))
+	if err != nil {
+		switch err {
+		case config.ErrUserInvalidPassword:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case config.ErrTooManyAttempts:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		case config.ErrPasswordTooShort, config.ErrPasswordBlocked:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error changing password:\n error: %s", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// RefreshToken принимает refresh-токен из тела запроса {"refresh_token": "..."}
+// или из cookie и выдает новую пару токенов.
+func (e *Endpoint) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	req := make(map[string]string, 0)
+	if len(buf) > 0 {
+		err = json.Unmarshal(buf, &req)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	refresh := req["refresh_token"]
+	if refresh == "" {
+		if cookie, err := r.Cookie(config.RefreshCookieName); err == nil {
+			refresh = cookie.Value
+		}
+	}
+	if refresh == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := e.srv.RefreshSession(r.Context(), refresh)
+	if err != nil {
+		switch err {
+		case config.ErrNoSuchRecord, config.ErrTokenExpired, config.ErrRefreshTokenReused, config.ErrUserLocked:
+			http.Error(w, "Unautorized", http.StatusUnauthorized)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error refreshing session:\n error: %s", err)
+		}
+		return
+	}
+	e.writeSession(w, r, tokens)
+}
+
+// clearSession удаляет cookie сессии, refresh-токена и CSRF-токена.
+func (e *Endpoint) clearSession(w http.ResponseWriter) {
+	for _, c := range []*http.Cookie{
+		e.newCookie(config.CookieName, "", "/", time.Time{}, true),
+		e.newCookie(config.RefreshCookieName, "", refreshCookiePath, time.Time{}, true),
+		e.newCookie(config.CSRFCookieName, "", "/", time.Time{}, false),
+	} {
+		c.MaxAge = -1
+		http.SetCookie(w, c)
+	}
+}
+
+// newCookie создает cookie с атрибутами Secure, SameSite и Domain из конфигурации.
+func (e *Endpoint) newCookie(name, value, path string, expires time.Time, httpOnly bool) *http.Cookie {
+	sameSite, _ := e.cfg.SameSite()
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   e.cfg.CookieDomain,
+		Expires:  expires,
+		HttpOnly: httpOnly,
+		Secure:   e.cfg.CookieSecure,
+		SameSite: sameSite,
+	}
+}
+
+// clientIP - адрес клиента; RemoteAddr уже подменен middleware.RealIP,
+// если запрос пришел через прокси.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// writeSession выдает токены в cookie, а клиентам, запросившим JSON
+// (Accept: application/json), дополнительно возвращает их в теле ответа
+// для использования в заголовке Authorization: Bearer.
+func (e *Endpoint) writeSession(w http.ResponseWriter, r *http.Request, tokens model.AuthTokens) {
+	http.SetCookie(w, e.newCookie(config.CookieName, tokens.Access, "/", tokens.AccessExpires, true))
+	http.SetCookie(w, e.newCookie(config.RefreshCookieName, tokens.Refresh, refreshCookiePath, tokens.RefreshExpires, true))
+	// CSRF-токен читается скриптом страницы и возвращается в заголовке X-CSRF-Token
+	http.SetCookie(w, e.newCookie(config.CSRFCookieName, util.CSRFToken(tokens.Access), "/", tokens.AccessExpires, false))
+	if !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalAuthTokenDoc(tokens))
+}
+
 func (e *Endpoint) NewOrder(w http.ResponseWriter, r *http.Request) {
 	buf, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -165,6 +423,8 @@ func (e *Endpoint) NewWithdraw(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		case config.ErrNotEnoughAccruals:
 			http.Error(w, err.Error(), http.StatusPaymentRequired)
+		case config.ErrWithdrawOrderUsed:
+			http.Error(w, err.Error(), http.StatusConflict)
 		default:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -175,6 +435,24 @@ func (e *Endpoint) NewWithdraw(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Ok"))
 }
 
+// CancelWithdraw - отмена списания по номеру заказа в течение WITHDRAW_CANCEL_WINDOW.
+func (e *Endpoint) CancelWithdraw(w http.ResponseWriter, r *http.Request) {
+	err := e.srv.CancelWithdraw(r.Context(), chi.URLParam(r, "order"))
+	if err != nil {
+		switch err {
+		case config.ErrNoSuchRecord:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case config.ErrWithdrawReversed, config.ErrCancelWindowExpired:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error cancelling withdrawal:\n error: %s", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func (e *Endpoint) UserWithdraws(w http.ResponseWriter, r *http.Request) {
 	res, err := e.srv.GetWithdrawList(r.Context())
 	if err != nil {
@@ -191,8 +469,19 @@ func (e *Endpoint) UserWithdraws(w http.ResponseWriter, r *http.Request) {
 	w.Write(buf)
 }
 
+// UserBalance - текущий баланс или, с параметром ?at=<RFC 3339 или дата>, баланс на этот момент.
 func (e *Endpoint) UserBalance(w http.ResponseWriter, r *http.Request) {
-	res, err := e.srv.GetBalance(r.Context())
+	at, err := parseTimeParam(r.URL.Query().Get("at"))
+	if err != nil {
+		http.Error(w, "invalid at", http.StatusBadRequest)
+		return
+	}
+	var res model.Balance
+	if at.IsZero() {
+		res, err = e.srv.GetBalance(r.Context())
+	} else {
+		res, err = e.srv.GetBalanceAt(r.Context(), at)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
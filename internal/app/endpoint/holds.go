@@ -0,0 +1,88 @@
+package endpoint
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// NewHold - резерв баллов под заказ {"order": "...", "sum": 100}, в ответе идентификатор резерва.
+func (e *Endpoint) NewHold(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	req, err := model.UnmarshalWithdrawRequest(buf)
+	if err != nil || req.OrderID == "" || req.Withdraw <= 0 {
+		http.Error(w, "error in request", http.StatusUnprocessableEntity)
+		return
+	}
+	h, err := e.srv.ReserveHold(r.Context(), req.OrderID, req.Withdraw)
+	if err != nil {
+		holdError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(model.MarshalHoldDoc(h))
+}
+
+func (e *Endpoint) CaptureHold(w http.ResponseWriter, r *http.Request) {
+	h, err := e.srv.CaptureHold(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		holdError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalHoldDoc(h))
+}
+
+func (e *Endpoint) ReleaseHold(w http.ResponseWriter, r *http.Request) {
+	h, err := e.srv.ReleaseHold(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		holdError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalHoldDoc(h))
+}
+
+func (e *Endpoint) UserHolds(w http.ResponseWriter, r *http.Request) {
+	res, err := e.srv.GetHolds(r.Context())
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Printf("error getting holds:\n error: %s", err)
+		return
+	}
+	if len(res) == 0 {
+		http.Error(w, "no data", http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalHoldsDoc(res))
+}
+
+func holdError(w http.ResponseWriter, err error) {
+	switch err {
+	case config.ErrLuhnCheckFailed:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case config.ErrNotEnoughAccruals:
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+	case config.ErrNoSuchRecord:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case config.ErrWithdrawOrderUsed, config.ErrHoldSettled, config.ErrHoldExpired:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Printf("hold error:\n error: %s", err)
+	}
+}
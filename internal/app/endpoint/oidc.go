@@ -0,0 +1,77 @@
+package endpoint
+
+import (
+	"log"
+	"net/http"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+)
+
+// cookie состояния входа отправляется браузером только на эндпоинты OIDC
+const oidcCookiePath = "/api/user/oidc"
+
+// OIDCLogin перенаправляет браузер на страницу входа провайдера OpenID Connect.
+func (e *Endpoint) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := e.srv.StartOIDCLogin(r.Context())
+	if err != nil {
+		switch err {
+		case config.ErrOIDCLogin:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error starting oidc login:\n error: %s", err)
+		}
+		return
+	}
+	http.SetCookie(w, e.oidcStateCookie(state, time.Now().Add(config.OIDCLoginDuration)))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback - возврат от провайдера с кодом авторизации, выдает обычную сессию.
+func (e *Endpoint) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("error") != "" {
+		http.Error(w, "login rejected by provider: "+q.Get("error"), http.StatusUnauthorized)
+		return
+	}
+	stateCookie, err := r.Cookie(config.OIDCStateCookieName)
+	if err != nil || q.Get("state") == "" || q.Get("code") == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	// состояние одноразовое, cookie удаляется при любом исходе
+	expired := e.oidcStateCookie("", time.Time{})
+	expired.MaxAge = -1
+	http.SetCookie(w, expired)
+
+	tokens, err := e.srv.FinishOIDCLogin(r.Context(), stateCookie.Value, q.Get("state"), q.Get("code"))
+	if err != nil {
+		switch err {
+		case config.ErrInvalidToken:
+			http.Error(w, "invalid or expired login state", http.StatusBadRequest)
+		case config.ErrOIDCLogin:
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case config.ErrUserLocked:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case config.ErrSecondFactorRequired:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			w.Write(model.MarshalMFAChallengeDoc(tokens))
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error finishing oidc login:\n error: %s", err)
+		}
+		return
+	}
+	e.writeSession(w, r, tokens)
+}
+
+// возврат от провайдера - переход с другого сайта, при SameSite=Strict cookie не была бы отправлена
+func (e *Endpoint) oidcStateCookie(value string, expires time.Time) *http.Cookie {
+	c := e.newCookie(config.OIDCStateCookieName, value, oidcCookiePath, expires, true)
+	if c.SameSite == http.SameSiteStrictMode {
+		c.SameSite = http.SameSiteLaxMode
+	}
+	return c
+}
@@ -0,0 +1,77 @@
+package endpoint
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+)
+
+func (e *Endpoint) UserProfile(w http.ResponseWriter, r *http.Request) {
+	res, err := e.srv.GetProfile(r.Context())
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Printf("error getting profile:\n error: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalProfileDoc(res))
+}
+
+// UpdateProfile - изменение профиля {"email": "...", "display_name": "...", "locale": "..."}.
+// На новый адрес отправляется письмо со ссылкой подтверждения.
+func (e *Endpoint) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	req, err := model.UnmarshalProfileRequest(buf)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	res, err := e.srv.UpdateProfile(r.Context(), req)
+	if err != nil {
+		switch err {
+		case config.ErrInvalidEmail, config.ErrInvalidProfile:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case config.ErrEmailBusy:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error updating profile:\n error: %s", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalProfileDoc(res))
+}
+
+// VerifyEmail - переход по ссылке из письма, аутентификация не требуется.
+func (e *Endpoint) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err := e.srv.VerifyEmail(r.Context(), token)
+	if err != nil {
+		switch err {
+		case config.ErrInvalidToken, config.ErrTokenExpired:
+			http.Error(w, "verification link is invalid or expired", http.StatusBadRequest)
+		case config.ErrEmailBusy:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error verifying email:\n error: %s", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("email address confirmed"))
+}
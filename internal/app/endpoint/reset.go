@@ -0,0 +1,70 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"yp-diploma/internal/app/config"
+)
+
+// ForgotPassword - запрос сброса пароля {"email": "..."}. Ответ всегда 202,
+// чтобы по нему нельзя было узнать, зарегистрирован ли адрес.
+func (e *Endpoint) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	req := make(map[string]string, 0)
+	err = json.Unmarshal(buf, &req)
+	if err != nil || req["email"] == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = e.srv.ForgotPassword(r.Context(), req["email"], clientIP(r))
+	if err != nil {
+		switch err {
+		case config.ErrTooManyAttempts:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error requesting password reset:\n error: %s", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword - установка нового пароля {"token": "...", "new_password": "..."}.
+func (e *Endpoint) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	req := make(map[string]string, 0)
+	err = json.Unmarshal(buf, &req)
+	if err != nil || req["token"] == "" || req["new_password"] == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = e.srv.ResetPassword(r.Context(), req["token"], req["new_password"], clientIP(r))
+	if err != nil {
+		switch err {
+		case config.ErrInvalidToken:
+			http.Error(w, "reset token is invalid or expired", http.StatusBadRequest)
+		case config.ErrPasswordTooShort, config.ErrPasswordBlocked:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case config.ErrTooManyAttempts:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error resetting password:\n error: %s", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,56 @@
+package endpoint
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// UserStatement - выписка за календарный месяц /api/user/statements/2024-05,
+// формат выбирается параметром ?format=json|csv или заголовком Accept: text/csv.
+func (e *Endpoint) UserStatement(w http.ResponseWriter, r *http.Request) {
+	month, err := time.ParseInLocation("2006-01", chi.URLParam(r, "month"), time.Local)
+	if err != nil {
+		http.Error(w, "invalid month, expected YYYY-MM", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			format = "csv"
+		}
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "unsupported format", http.StatusBadRequest)
+		return
+	}
+	st, err := e.srv.GetStatement(r.Context(), month)
+	if err != nil {
+		switch err {
+		case config.ErrInvalidData:
+			http.Error(w, "statement period has not started", http.StatusBadRequest)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error building statement:\n error: %s", err)
+		}
+		return
+	}
+	filename := fmt.Sprintf("statement-%s.%s", month.Format("2006-01"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(model.MarshalStatementCSV(st))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalStatementDoc(st))
+}
@@ -0,0 +1,70 @@
+package endpoint
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+)
+
+// UserTransactions - история операций с баллами по журналу:
+// ?type=accrual,withdrawal&from=2024-01-01&to=2024-02-01&limit=50&cursor=...
+func (e *Endpoint) UserTransactions(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	var q model.LedgerQuery
+	for _, v := range params["type"] {
+		for _, kind := range strings.Split(v, ",") {
+			if kind = strings.TrimSpace(kind); kind != "" {
+				q.Kinds = append(q.Kinds, kind)
+			}
+		}
+	}
+	var err error
+	if q.From, err = parseTimeParam(params.Get("from")); err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	if q.To, err = parseTimeParam(params.Get("to")); err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	res, err := e.srv.GetTransactions(r.Context(), q, params.Get("cursor"))
+	if err != nil {
+		switch err {
+		case config.ErrInvalidData:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error getting transactions:\n error: %s", err)
+		}
+		return
+	}
+	if len(res.Entries) == 0 {
+		http.Error(w, "no data", http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalTransactionsDoc(res))
+}
+
+// parseTimeParam разбирает время в формате RFC 3339 или дату 2006-01-02 (начало суток
+// по времени сервера). Пустое значение - нулевое время.
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,60 @@
+package endpoint
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+)
+
+// NewTransfer - перевод баллов другому пользователю {"login": "...", "sum": 100}.
+func (e *Endpoint) NewTransfer(w http.ResponseWriter, r *http.Request) {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	recipient, amount, err := model.UnmarshalTransferRequest(buf)
+	if err != nil || recipient == "" || amount <= 0 {
+		http.Error(w, "error in request", http.StatusUnprocessableEntity)
+		return
+	}
+	t, err := e.srv.Transfer(r.Context(), recipient, amount)
+	if err != nil {
+		switch err {
+		case config.ErrNoSuchRecord:
+			http.Error(w, "recipient not found", http.StatusNotFound)
+		case config.ErrTransferToSelf:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case config.ErrNotEnoughAccruals:
+			http.Error(w, err.Error(), http.StatusPaymentRequired)
+		case config.ErrTransferLimit:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Printf("error transferring points:\n error: %s", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalTransferDoc(t))
+}
+
+func (e *Endpoint) UserTransfers(w http.ResponseWriter, r *http.Request) {
+	res, err := e.srv.GetTransfers(r.Context())
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Printf("error getting transfers:\n error: %s", err)
+		return
+	}
+	if len(res) == 0 {
+		http.Error(w, "no data", http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(model.MarshalTransfersDoc(res))
+}
@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"time"
+
+	"yp-diploma/internal/app/config"
+)
+
+// Message - текстовое письмо одному получателю.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender отправляет письма пользователям. Реализация выбирается переменной MAIL_TRANSPORT:
+// smtp - отправка через SMTP-сервер, file - запись писем в каталог MAIL_DIR (для разработки и тестов).
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+func New(cfg *config.Config) (Sender, error) {
+	switch cfg.MailTransport {
+	case "smtp":
+		return NewSMTPSender(cfg.SMTPAddr, cfg.SMTPUser, cfg.SMTPPassword, cfg.MailFrom)
+	case "file":
+		return NewFileSender(cfg.MailDir, cfg.MailFrom)
+	default:
+		return nil, fmt.Errorf("unsupported MAIL_TRANSPORT value: %s", cfg.MailTransport)
+	}
+}
+
+// compose формирует письмо в формате RFC 5322.
+func compose(from string, msg Message, date time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(msg.Body)
+	return buf.Bytes()
+}
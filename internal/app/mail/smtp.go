@@ -0,0 +1,120 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// sendTimeout ограничивает отправку письма, если в контексте не задан срок.
+const sendTimeout = 30 * time.Second
+
+type SMTPSender struct {
+	addr string
+	host string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPSender создает отправителя через SMTP-сервер addr (host:port). Если задан user,
+// используется PLAIN-аутентификация, net/smtp допускает ее только поверх TLS или на localhost.
+func NewSMTPSender(addr, user, password, from string) (*SMTPSender, error) {
+	if addr == "" {
+		return nil, errors.New("SMTP_ADDR is required for smtp mail transport")
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &SMTPSender{addr: addr, host: host, from: from}
+	if user != "" {
+		s.auth = smtp.PlainAuth("", user, password, host)
+	}
+	return s, nil
+}
+
+// Send повторяет smtp.SendMail, но соединение устанавливается и обслуживается с учетом ctx:
+// срок контекста становится сроком соединения, а отмена контекста закрывает соединение.
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err == nil {
+		defer c.Close()
+		err = s.send(c, msg)
+	} else {
+		conn.Close()
+	}
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (s *SMTPSender) send(c *smtp.Client, msg Message) error {
+	err := c.Hello("localhost")
+	if err != nil {
+		return err
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: s.host})
+		if err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		err = c.Auth(s.auth)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.Mail(s.from)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(msg.To)
+	if err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(compose(s.from, msg, time.Now()))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
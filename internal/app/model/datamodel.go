@@ -30,13 +30,73 @@ type Balance = struct {
 	Accrual  int
 	Withdraw int
 	Balance  int
+	Held     int
+	// Expiring баллов сгорят до ExpiringBefore, если не будут израсходованы
+	Expiring       int
+	ExpiringBefore time.Time
 }
 
+// Состояния списания: отмененное пользователем или возвращенное администратором
+// списание остается в истории, баллы возвращаются проводкой сторно.
+const (
+	WithdrawDone      = "DONE"
+	WithdrawCancelled = "CANCELLED"
+	WithdrawRefunded  = "REFUNDED"
+)
+
 type Withdraw = struct {
+	ID       int64
 	OrderID  string
 	UserID   string
 	GenTime  time.Time
 	Withdraw int
+	Status   string
+}
+
+// Состояния резерва баллов. Резерв в состоянии HoldActive уменьшает доступный баланс,
+// при подтверждении превращается в списание, при отмене или истечении срока баллы возвращаются.
+const (
+	HoldActive   = "HELD"
+	HoldCaptured = "CAPTURED"
+	HoldReleased = "RELEASED"
+	HoldExpired  = "EXPIRED"
+)
+
+type Hold struct {
+	ID      string
+	UserID  string
+	OrderID string
+	Amount  int
+	Status  string
+	GenTime time.Time
+	Expires time.Time
+}
+
+// Transfer - перевод баллов между пользователями. Counterparty - логин второго участника,
+// Incoming - перевод получен текущим пользователем.
+type Transfer struct {
+	ID           int64
+	SenderID     string
+	RecipientID  string
+	Counterparty string
+	Incoming     bool
+	Amount       int
+	GenTime      time.Time
+}
+
+type Adjustment struct {
+	UserID  string
+	AdminID string
+	Amount  int
+	Reason  string
+	GenTime time.Time
+}
+
+type DispatcherState struct {
+	Orders     []Order
+	LastRun    time.Time
+	LastBatch  int
+	LastErrors int
 }
 
 type Accrual struct {
@@ -44,3 +104,103 @@ type Accrual struct {
 	Status  string
 	Accrual int
 }
+
+// IdempotentResponse - сохраненный ответ на запрос с ключом идемпотентности.
+// Status == 0, пока первый запрос еще обрабатывается.
+type IdempotentResponse struct {
+	UserID      string
+	Key         string
+	RequestHash string
+	Status      int
+	ContentType string
+	Body        []byte
+	Created     time.Time
+}
+
+// Виды проводок журнала баллов.
+const (
+	LedgerAccrual     = "accrual"
+	LedgerWithdrawal  = "withdrawal"
+	LedgerAdjustment  = "adjustment"
+	LedgerReversal    = "reversal"
+	LedgerExpiry      = "expiry"
+	LedgerHold        = "hold"
+	LedgerCapture     = "capture"
+	LedgerRelease     = "release"
+	LedgerTransferOut = "transfer_out"
+	LedgerTransferIn  = "transfer_in"
+)
+
+// Счета журнала баллов. AccountUser - счет пользователя проводки, остальные - системные.
+const (
+	AccountUser        = "user"
+	AccountAccrual     = "system:accrual"
+	AccountWithdrawals = "system:withdrawals"
+	AccountAdjustments = "system:adjustments"
+	AccountExpiry      = "system:expiry"
+	AccountHolds       = "system:holds"
+	AccountTransfers   = "system:transfers"
+)
+
+// LedgerEntry - проводка: Amount переходит со счета Debit на счет Credit.
+// Balance - баланс пользователя после проводки.
+type LedgerEntry struct {
+	ID      int64
+	UserID  string
+	Kind    string
+	Debit   string
+	Credit  string
+	Amount  int
+	Ref     string
+	Balance int
+	GenTime time.Time
+}
+
+// LedgerKinds - виды проводок, по которым можно отфильтровать историю операций.
+var LedgerKinds = []string{LedgerAccrual, LedgerWithdrawal, LedgerAdjustment, LedgerReversal, LedgerExpiry,
+	LedgerHold, LedgerCapture, LedgerRelease, LedgerTransferOut, LedgerTransferIn}
+
+// LedgerQuery - выборка проводок пользователя по возрастанию ID. Проводки одного пользователя
+// выполняются под блокировкой его баланса, поэтому порядок ID совпадает с порядком изменения
+// баланса; GenTime проставляется до транзакции и может его нарушать.
+// Нулевые From и To не ограничивают период, AfterID - позиция курсора.
+type LedgerQuery struct {
+	UserID  string
+	Kinds   []string
+	From    time.Time
+	To      time.Time
+	AfterID int64
+	Limit   int
+}
+
+// Statement - выписка по счету пользователя за период [From, To): баланс на начало,
+// сумма поступлений и расходов, баланс на конец и проводки периода.
+type Statement struct {
+	UserID  string
+	From    time.Time
+	To      time.Time
+	Opening int
+	Credits int
+	Debits  int
+	Closing int
+	Entries []LedgerEntry
+}
+
+// LedgerPage - страница истории операций, Next - курсор следующей страницы или "".
+type LedgerPage struct {
+	Entries []LedgerEntry
+	Next    string
+}
+
+// Delta - изменение баланса пользователя проводкой. Проводка между системными
+// счетами (подтверждение резерва) баланс не меняет.
+func (e LedgerEntry) Delta() int {
+	switch {
+	case e.Credit == AccountUser:
+		return e.Amount
+	case e.Debit == AccountUser:
+		return -e.Amount
+	default:
+		return 0
+	}
+}
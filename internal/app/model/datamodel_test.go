@@ -0,0 +1,29 @@
+package model
+
+import "testing"
+
+func TestLedgerEntryDelta(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry LedgerEntry
+		want  int
+	}{
+		{"accrual", LedgerEntry{Kind: LedgerAccrual, Debit: AccountAccrual, Credit: AccountUser, Amount: 500}, 500},
+		{"withdrawal", LedgerEntry{Kind: LedgerWithdrawal, Debit: AccountUser, Credit: AccountWithdrawals, Amount: 300}, -300},
+		{"reversal", LedgerEntry{Kind: LedgerReversal, Debit: AccountWithdrawals, Credit: AccountUser, Amount: 300}, 300},
+		{"negative adjustment", LedgerEntry{Kind: LedgerAdjustment, Debit: AccountUser, Credit: AccountAdjustments, Amount: 100}, -100},
+		{"expiry", LedgerEntry{Kind: LedgerExpiry, Debit: AccountUser, Credit: AccountExpiry, Amount: 50}, -50},
+		{"hold", LedgerEntry{Kind: LedgerHold, Debit: AccountUser, Credit: AccountHolds, Amount: 200}, -200},
+		{"capture", LedgerEntry{Kind: LedgerCapture, Debit: AccountHolds, Credit: AccountWithdrawals, Amount: 200}, 0},
+		{"release", LedgerEntry{Kind: LedgerRelease, Debit: AccountHolds, Credit: AccountUser, Amount: 200}, 200},
+		{"transfer out", LedgerEntry{Kind: LedgerTransferOut, Debit: AccountUser, Credit: AccountTransfers, Amount: 70}, -70},
+		{"transfer in", LedgerEntry{Kind: LedgerTransferIn, Debit: AccountTransfers, Credit: AccountUser, Amount: 70}, 70},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.Delta(); got != tt.want {
+				t.Errorf("Delta() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
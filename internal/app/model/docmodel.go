@@ -1,6 +1,8 @@
 package model
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"math"
@@ -14,17 +16,29 @@ type points uint
 
 const pointDivider int = 100
 
+// WholePoints переводит целое количество баллов в единицы хранения (сотые доли балла).
+func WholePoints(n int) int {
+	return n * pointDivider
+}
+
 func (dt docTime) MarshalJSON() ([]byte, error) {
-	res := fmt.Sprintf("\"%s\"", time.Time(dt).Format("2006-01-02T15:04:05-07:00"))
+	res := fmt.Sprintf("\"%s\"", formatDocTime(time.Time(dt)))
 	return []byte(res), nil
 }
 
-func (p points) MarshalJSON() ([]byte, error) {
-	res := fmt.Sprint(float64(p) / float64(pointDivider))
+func formatDocTime(t time.Time) string {
+	return t.Format("2006-01-02T15:04:05-07:00")
+}
+
+func (p points) String() string {
 	if int(p)%pointDivider == 0 {
-		res = fmt.Sprintf("%d", int(p)/pointDivider)
+		return fmt.Sprintf("%d", int(p)/pointDivider)
 	}
-	return []byte(res), nil
+	return fmt.Sprint(float64(p) / float64(pointDivider))
+}
+
+func (p points) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
 }
 
 func (p *points) UnmarshalJSON(data []byte) error {
@@ -44,15 +58,67 @@ type orderDoc struct {
 }
 
 type withdrawDoc struct {
+	ID       int64   `json:"id"`
 	OrderID  string  `json:"order"`
 	Withdraw points  `json:"sum"`
+	Status   string  `json:"status"`
 	GenTime  docTime `json:"processed_at"`
 }
 
 type balanceDoc struct {
-	Balance  points `json:"current"`
-	Accrual  points `json:"-"`
-	Withdraw points `json:"withdrawn,omitempty"`
+	Balance        points   `json:"current"`
+	Held           points   `json:"held"`
+	Accrual        points   `json:"-"`
+	Withdraw       points   `json:"withdrawn"`
+	Expiring       points   `json:"expiring_soon,omitempty"`
+	ExpiringBefore *docTime `json:"expiring_before,omitempty"`
+}
+
+type holdDoc struct {
+	ID      string  `json:"id"`
+	OrderID string  `json:"order"`
+	Amount  points  `json:"sum"`
+	Status  string  `json:"status"`
+	GenTime docTime `json:"created_at"`
+	Expires docTime `json:"expires_at"`
+}
+
+type transactionDoc struct {
+	ID      int64   `json:"id"`
+	Kind    string  `json:"type"`
+	Amount  points  `json:"sum"`
+	Change  float64 `json:"change"`
+	Balance points  `json:"balance"`
+	Ref     string  `json:"ref,omitempty"`
+	GenTime docTime `json:"processed_at"`
+}
+
+type transactionPageDoc struct {
+	Transactions []transactionDoc `json:"transactions"`
+	Next         string           `json:"next_cursor,omitempty"`
+}
+
+type statementDoc struct {
+	From         docTime          `json:"period_start"`
+	To           docTime          `json:"period_end"`
+	Opening      points           `json:"opening_balance"`
+	Credits      points           `json:"credits"`
+	Debits       points           `json:"debits"`
+	Closing      points           `json:"closing_balance"`
+	Transactions []transactionDoc `json:"transactions"`
+}
+
+type transferDoc struct {
+	ID           int64   `json:"id"`
+	Direction    string  `json:"direction"`
+	Counterparty string  `json:"login"`
+	Amount       points  `json:"sum"`
+	GenTime      docTime `json:"processed_at"`
+}
+
+type transferReq struct {
+	Recipient string `json:"login"`
+	Amount    points `json:"sum"`
 }
 
 type withdrawReq struct {
@@ -60,6 +126,105 @@ type withdrawReq struct {
 	Withdraw points `json:"sum"`
 }
 
+type authTokenDoc struct {
+	Token          string  `json:"token"`
+	TokenType      string  `json:"token_type"`
+	Expires        docTime `json:"expires_at"`
+	RefreshToken   string  `json:"refresh_token"`
+	RefreshExpires docTime `json:"refresh_expires_at"`
+}
+
+type mfaChallengeDoc struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type totpEnrollmentDoc struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"otpauth_uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type apiKeyDoc struct {
+	ID       string   `json:"id"`
+	Key      string   `json:"key,omitempty"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+	Created  docTime  `json:"created_at"`
+	Expires  *docTime `json:"expires_at,omitempty"`
+	LastUsed *docTime `json:"last_used_at,omitempty"`
+}
+
+type apiKeyReq struct {
+	Name    string     `json:"name"`
+	Scopes  []string   `json:"scopes"`
+	Expires *time.Time `json:"expires_at"`
+}
+
+type userDoc struct {
+	ID     string `json:"id"`
+	Name   string `json:"login"`
+	Role   string `json:"role"`
+	Locked bool   `json:"locked"`
+}
+
+type dispatcherDoc struct {
+	Pending    int        `json:"pending"`
+	LastRun    *docTime   `json:"last_run,omitempty"`
+	LastBatch  int        `json:"last_batch"`
+	LastErrors int        `json:"last_errors"`
+	Orders     []orderDoc `json:"orders"`
+}
+
+// сумма корректировки может быть отрицательной, поэтому не points
+type adjustmentReq struct {
+	Amount float64 `json:"sum"`
+	Reason string  `json:"reason"`
+}
+
+type profileDoc struct {
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"email_verified"`
+	PendingEmail  string   `json:"pending_email,omitempty"`
+	DisplayName   string   `json:"display_name"`
+	Locale        string   `json:"locale"`
+	Updated       *docTime `json:"updated_at,omitempty"`
+}
+
+type profileReq struct {
+	Email       *string `json:"email"`
+	DisplayName *string `json:"display_name"`
+	Locale      *string `json:"locale"`
+}
+
+type sessionDoc struct {
+	FamilyID   string  `json:"family_id,omitempty"`
+	Expires    docTime `json:"expires_at"`
+	MaxExpires docTime `json:"max_expires_at"`
+}
+
+type adjustmentDoc struct {
+	Amount  float64 `json:"sum"`
+	Reason  string  `json:"reason"`
+	GenTime docTime `json:"processed_at"`
+}
+
+type accountExportDoc struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"login"`
+	Role        string          `json:"role"`
+	TOTPEnabled bool            `json:"totp_enabled"`
+	Profile     profileDoc      `json:"profile"`
+	Balance     balanceDoc      `json:"balance"`
+	Orders      []orderDoc      `json:"orders"`
+	Withdraws   []withdrawDoc   `json:"withdrawals"`
+	Transfers   []transferDoc   `json:"transfers"`
+	Adjustments []adjustmentDoc `json:"adjustments"`
+	Sessions    []sessionDoc    `json:"sessions"`
+	APIKeys     []apiKeyDoc     `json:"api_keys"`
+	GenTime     docTime         `json:"exported_at"`
+}
+
 type accrualResp struct {
 	OrderID string `json:"order"`
 	Status  string `json:"status"`
@@ -87,9 +252,11 @@ func MarshalUserWithdrawsDoc(withdraws []Withdraw) []byte {
 	}
 	docs := make([]withdrawDoc, len(withdraws))
 	for i := range withdraws {
+		docs[i].ID = withdraws[i].ID
 		docs[i].OrderID = withdraws[i].OrderID
 		docs[i].GenTime = docTime(withdraws[i].GenTime)
 		docs[i].Withdraw = points(withdraws[i].Withdraw)
+		docs[i].Status = withdraws[i].Status
 	}
 	buf, _ := json.MarshalIndent(docs, "", " ")
 	return buf
@@ -112,6 +279,365 @@ func MarshalUserBalanceDoc(balance Balance) []byte {
 		Accrual:  points(balance.Accrual),
 		Withdraw: points(balance.Withdraw),
 		Balance:  points(balance.Balance),
+		Held:     points(balance.Held),
+		Expiring: points(balance.Expiring),
+	}
+	if balance.Expiring > 0 {
+		before := docTime(balance.ExpiringBefore)
+		doc.ExpiringBefore = &before
+	}
+	buf, _ := json.MarshalIndent(doc, "", " ")
+	return buf
+}
+
+func newHoldDoc(h Hold) holdDoc {
+	return holdDoc{
+		ID:      h.ID,
+		OrderID: h.OrderID,
+		Amount:  points(h.Amount),
+		Status:  h.Status,
+		GenTime: docTime(h.GenTime),
+		Expires: docTime(h.Expires),
+	}
+}
+
+func MarshalHoldDoc(h Hold) []byte {
+	buf, _ := json.MarshalIndent(newHoldDoc(h), "", " ")
+	return buf
+}
+
+func MarshalHoldsDoc(holds []Hold) []byte {
+	docs := make([]holdDoc, len(holds))
+	for i, h := range holds {
+		docs[i] = newHoldDoc(h)
+	}
+	buf, _ := json.MarshalIndent(docs, "", " ")
+	return buf
+}
+
+func newTransactionDoc(e LedgerEntry) transactionDoc {
+	return transactionDoc{
+		ID:      e.ID,
+		Kind:    e.Kind,
+		Amount:  points(e.Amount),
+		Change:  float64(e.Delta()) / float64(pointDivider),
+		Balance: points(e.Balance),
+		Ref:     e.Ref,
+		GenTime: docTime(e.GenTime),
+	}
+}
+
+// MarshalStatementDoc - выписка за месяц, period_end не входит в период.
+func MarshalStatementDoc(st Statement) []byte {
+	doc := statementDoc{
+		From:         docTime(st.From),
+		To:           docTime(st.To),
+		Opening:      points(st.Opening),
+		Credits:      points(st.Credits),
+		Debits:       points(st.Debits),
+		Closing:      points(st.Closing),
+		Transactions: make([]transactionDoc, len(st.Entries)),
+	}
+	for i, e := range st.Entries {
+		doc.Transactions[i] = newTransactionDoc(e)
+	}
+	buf, _ := json.MarshalIndent(doc, "", " ")
+	return buf
+}
+
+// MarshalStatementCSV - выписка в CSV: строка opening с балансом на начало, проводки
+// с суммами поступления (credit) или расхода (debit) и строка closing с итогами периода.
+func MarshalStatementCSV(st Statement) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"processed_at", "type", "ref", "credit", "debit", "balance"})
+	w.Write([]string{formatDocTime(st.From), "opening", "", "", "", points(st.Opening).String()})
+	for _, e := range st.Entries {
+		credit, debit := "", ""
+		switch delta := e.Delta(); {
+		case delta > 0:
+			credit = points(delta).String()
+		case delta < 0:
+			debit = points(-delta).String()
+		}
+		w.Write([]string{formatDocTime(e.GenTime), e.Kind, e.Ref, credit, debit, points(e.Balance).String()})
+	}
+	w.Write([]string{formatDocTime(st.To), "closing", "", points(st.Credits).String(), points(st.Debits).String(),
+		points(st.Closing).String()})
+	w.Flush()
+	return buf.Bytes()
+}
+
+// MarshalTransactionsDoc - страница истории операций: change - изменение доступного баланса,
+// balance - баланс после операции.
+func MarshalTransactionsDoc(page LedgerPage) []byte {
+	doc := transactionPageDoc{
+		Transactions: make([]transactionDoc, len(page.Entries)),
+		Next:         page.Next,
+	}
+	for i, e := range page.Entries {
+		doc.Transactions[i] = newTransactionDoc(e)
+	}
+	buf, _ := json.MarshalIndent(doc, "", " ")
+	return buf
+}
+
+// MarshalTransfersDoc - переводы пользователя, direction "in" - полученный, "out" - отправленный.
+func MarshalTransfersDoc(transfers []Transfer) []byte {
+	docs := make([]transferDoc, len(transfers))
+	for i, t := range transfers {
+		docs[i] = newTransferDoc(t)
+	}
+	buf, _ := json.MarshalIndent(docs, "", " ")
+	return buf
+}
+
+func MarshalTransferDoc(t Transfer) []byte {
+	buf, _ := json.MarshalIndent(newTransferDoc(t), "", " ")
+	return buf
+}
+
+func newTransferDoc(t Transfer) transferDoc {
+	doc := transferDoc{
+		ID:           t.ID,
+		Direction:    "out",
+		Counterparty: t.Counterparty,
+		Amount:       points(t.Amount),
+		GenTime:      docTime(t.GenTime),
+	}
+	if t.Incoming {
+		doc.Direction = "in"
+	}
+	return doc
+}
+
+// UnmarshalTransferRequest - перевод {"login": "...", "sum": 100}.
+func UnmarshalTransferRequest(buf []byte) (string, int, error) {
+	req := transferReq{}
+	err := json.Unmarshal(buf, &req)
+	if err != nil {
+		return "", 0, err
+	}
+	return req.Recipient, int(req.Amount), nil
+}
+
+func MarshalAuthTokenDoc(tokens AuthTokens) []byte {
+	doc := authTokenDoc{
+		Token:          tokens.Access,
+		TokenType:      "Bearer",
+		Expires:        docTime(tokens.AccessExpires),
+		RefreshToken:   tokens.Refresh,
+		RefreshExpires: docTime(tokens.RefreshExpires),
+	}
+	buf, _ := json.MarshalIndent(doc, "", " ")
+	return buf
+}
+
+func MarshalMFAChallengeDoc(tokens AuthTokens) []byte {
+	doc := mfaChallengeDoc{
+		MFARequired: true,
+		MFAToken:    tokens.MFAToken,
+	}
+	buf, _ := json.MarshalIndent(doc, "", " ")
+	return buf
+}
+
+func MarshalTOTPEnrollmentDoc(enr TOTPEnrollment) []byte {
+	doc := totpEnrollmentDoc{
+		Secret:        enr.Secret,
+		URI:           enr.URI,
+		RecoveryCodes: enr.RecoveryCodes,
+	}
+	buf, _ := json.MarshalIndent(doc, "", " ")
+	return buf
+}
+
+func newAPIKeyDoc(key APIKey) apiKeyDoc {
+	doc := apiKeyDoc{
+		ID:      key.ID,
+		Name:    key.Name,
+		Scopes:  key.Scopes,
+		Created: docTime(key.Created),
+	}
+	if !key.Expires.IsZero() {
+		expires := docTime(key.Expires)
+		doc.Expires = &expires
+	}
+	if !key.LastUsed.IsZero() {
+		lastUsed := docTime(key.LastUsed)
+		doc.LastUsed = &lastUsed
+	}
+	return doc
+}
+
+// MarshalNewAPIKeyDoc - ответ на создание ключа, единственный раз, когда клиент видит сам ключ.
+func MarshalNewAPIKeyDoc(key APIKey, secret string) []byte {
+	doc := newAPIKeyDoc(key)
+	doc.Key = secret
+	buf, _ := json.MarshalIndent(doc, "", " ")
+	return buf
+}
+
+func MarshalAPIKeysDoc(keys []APIKey) []byte {
+	if len(keys) == 0 {
+		return []byte{}
+	}
+	docs := make([]apiKeyDoc, len(keys))
+	for i := range keys {
+		docs[i] = newAPIKeyDoc(keys[i])
+	}
+	buf, _ := json.MarshalIndent(docs, "", " ")
+	return buf
+}
+
+func UnmarshalAPIKeyRequest(buf []byte) (APIKey, error) {
+	req := apiKeyReq{}
+	err := json.Unmarshal(buf, &req)
+	if err != nil {
+		return APIKey{}, err
+	}
+	res := APIKey{
+		Name:   req.Name,
+		Scopes: req.Scopes,
+	}
+	if req.Expires != nil {
+		res.Expires = *req.Expires
+	}
+	return res, nil
+}
+
+func MarshalUsersDoc(users []User) []byte {
+	if len(users) == 0 {
+		return []byte{}
+	}
+	docs := make([]userDoc, len(users))
+	for i := range users {
+		docs[i].ID = users[i].ID
+		docs[i].Name = users[i].Name
+		docs[i].Role = users[i].Role
+		docs[i].Locked = users[i].Locked
+	}
+	buf, _ := json.MarshalIndent(docs, "", " ")
+	return buf
+}
+
+func MarshalDispatcherDoc(state DispatcherState) []byte {
+	doc := dispatcherDoc{
+		Pending:    len(state.Orders),
+		LastBatch:  state.LastBatch,
+		LastErrors: state.LastErrors,
+		Orders:     make([]orderDoc, len(state.Orders)),
+	}
+	if !state.LastRun.IsZero() {
+		lastRun := docTime(state.LastRun)
+		doc.LastRun = &lastRun
+	}
+	for i, order := range state.Orders {
+		doc.Orders[i].ID = order.ID
+		doc.Orders[i].GenTime = docTime(order.GenTime)
+		doc.Orders[i].Accrual = points(order.Accrual)
+		doc.Orders[i].Status = Statuses[order.Status]
+	}
+	buf, _ := json.MarshalIndent(doc, "", " ")
+	return buf
+}
+
+// UnmarshalAdjustmentRequest - ручная корректировка {"sum": -10.5, "reason": "..."}.
+func UnmarshalAdjustmentRequest(buf []byte) (Adjustment, error) {
+	req := adjustmentReq{}
+	err := json.Unmarshal(buf, &req)
+	if err != nil {
+		return Adjustment{}, err
+	}
+	return Adjustment{
+		Amount: int(math.Round(req.Amount * float64(pointDivider))),
+		Reason: req.Reason,
+	}, nil
+}
+
+func newProfileDoc(p Profile) profileDoc {
+	doc := profileDoc{
+		Email:         p.Email,
+		EmailVerified: p.Email != "",
+		PendingEmail:  p.PendingEmail,
+		DisplayName:   p.DisplayName,
+		Locale:        p.Locale,
+	}
+	if !p.Updated.IsZero() {
+		updated := docTime(p.Updated)
+		doc.Updated = &updated
+	}
+	return doc
+}
+
+func MarshalProfileDoc(p Profile) []byte {
+	buf, _ := json.MarshalIndent(newProfileDoc(p), "", " ")
+	return buf
+}
+
+// UnmarshalProfileRequest - изменения профиля, отсутствующие поля не меняются.
+func UnmarshalProfileRequest(buf []byte) (ProfileUpdate, error) {
+	req := profileReq{}
+	err := json.Unmarshal(buf, &req)
+	if err != nil {
+		return ProfileUpdate{}, err
+	}
+	return ProfileUpdate{
+		Email:       req.Email,
+		DisplayName: req.DisplayName,
+		Locale:      req.Locale,
+	}, nil
+}
+
+// MarshalAccountExportDoc - выгрузка данных пользователя, пустые разделы выводятся как [].
+func MarshalAccountExportDoc(exp AccountExport) []byte {
+	doc := accountExportDoc{
+		ID:          exp.User.ID,
+		Name:        exp.User.Name,
+		Role:        exp.User.Role,
+		TOTPEnabled: exp.TOTPEnabled,
+		Profile:     newProfileDoc(exp.Profile),
+		Balance: balanceDoc{
+			Balance:  points(exp.Balance.Balance),
+			Held:     points(exp.Balance.Held),
+			Withdraw: points(exp.Balance.Withdraw),
+		},
+		Orders:      make([]orderDoc, len(exp.Orders)),
+		Withdraws:   make([]withdrawDoc, len(exp.Withdraws)),
+		Transfers:   make([]transferDoc, len(exp.Transfers)),
+		Adjustments: make([]adjustmentDoc, len(exp.Adjustments)),
+		Sessions:    make([]sessionDoc, len(exp.Sessions)),
+		APIKeys:     make([]apiKeyDoc, len(exp.APIKeys)),
+		GenTime:     docTime(exp.GenTime),
+	}
+	for i, order := range exp.Orders {
+		doc.Orders[i].ID = order.ID
+		doc.Orders[i].GenTime = docTime(order.GenTime)
+		doc.Orders[i].Accrual = points(order.Accrual)
+		doc.Orders[i].Status = Statuses[order.Status]
+	}
+	for i, w := range exp.Withdraws {
+		doc.Withdraws[i].ID = w.ID
+		doc.Withdraws[i].OrderID = w.OrderID
+		doc.Withdraws[i].GenTime = docTime(w.GenTime)
+		doc.Withdraws[i].Withdraw = points(w.Withdraw)
+		doc.Withdraws[i].Status = w.Status
+	}
+	for i, t := range exp.Transfers {
+		doc.Transfers[i] = newTransferDoc(t)
+	}
+	for i, adj := range exp.Adjustments {
+		doc.Adjustments[i].Amount = float64(adj.Amount) / float64(pointDivider)
+		doc.Adjustments[i].Reason = adj.Reason
+		doc.Adjustments[i].GenTime = docTime(adj.GenTime)
+	}
+	for i, sess := range exp.Sessions {
+		doc.Sessions[i].FamilyID = sess.FamilyID
+		doc.Sessions[i].Expires = docTime(sess.Expires)
+		doc.Sessions[i].MaxExpires = docTime(sess.MaxExpires)
+	}
+	for i, key := range exp.APIKeys {
+		doc.APIKeys[i] = newAPIKeyDoc(key)
 	}
 	buf, _ := json.MarshalIndent(doc, "", " ")
 	return buf
@@ -2,14 +2,172 @@ package model
 
 import "time"
 
+// Области доступа API-ключей. ScopeAccount (управление учетной записью)
+// есть только у сессии пользователя и ключу выдана быть не может.
+const (
+	ScopeOrdersWrite = "orders:write"
+	ScopeOrdersRead  = "orders:read"
+	ScopeBalanceRead = "balance:read"
+	ScopeWithdraw    = "withdraw"
+	ScopeTransfer    = "transfer"
+	ScopeAccount     = "account"
+)
+
+// виды отзыва сессий в уведомлениях между экземплярами сервиса
+const (
+	RevokeSession = "sess"
+	RevokeFamily  = "family"
+	RevokeUser    = "user"
+)
+
+var APIKeyScopes = []string{ScopeOrdersWrite, ScopeOrdersRead, ScopeBalanceRead, ScopeWithdraw, ScopeTransfer}
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	ID           string
 	Name         string
 	HashedPasswd string
+	Role         string
+	Locked       bool
 }
 
 type SessKey struct {
+	ID         string
+	UserID     string
+	FamilyID   string
+	Expires    time.Time
+	MaxExpires time.Time
+}
+
+type RefreshToken struct {
+	ID       string
+	FamilyID string
+	UserID   string
+	Expires  time.Time
+}
+
+type AuthTokens struct {
+	Access         string
+	AccessExpires  time.Time
+	Refresh        string
+	RefreshExpires time.Time
+	// выдается вместо сессии, если у пользователя включен второй фактор
+	MFAToken string
+}
+
+type SessToken struct {
+	SessionID string
+	Expires   time.Time
+}
+
+type LoginAttempt struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+type TOTP struct {
+	UserID    string
+	Secret    string
+	Confirmed bool
+	LastStep  int64
+}
+
+type TOTPEnrollment struct {
+	Secret        string
+	URI           string
+	RecoveryCodes []string
+}
+
+type LoginChallenge struct {
+	ID       string
+	UserID   string
+	Expires  time.Time
+	Attempts int
+}
+
+type APIKey struct {
+	ID       string
+	UserID   string
+	Name     string
+	Secret   string
+	Scopes   []string
+	Created  time.Time
+	Expires  time.Time
+	LastUsed time.Time
+}
+
+type AuditRecord struct {
+	AdminID  string
+	Action   string
+	TargetID string
+	Details  string
+	GenTime  time.Time
+}
+
+// AccountExport - все данные пользователя для выгрузки по его запросу.
+type AccountExport struct {
+	User        User
+	Profile     Profile
+	TOTPEnabled bool
+	Balance     Balance
+	Orders      []Order
+	Withdraws   []Withdraw
+	Transfers   []Transfer
+	Adjustments []Adjustment
+	Sessions    []SessKey
+	APIKeys     []APIKey
+	GenTime     time.Time
+}
+
+// Profile - контактные данные пользователя. Email - подтвержденный адрес,
+// PendingEmail - новый адрес, ожидающий подтверждения по ссылке из письма.
+type Profile struct {
+	UserID       string
+	Email        string
+	PendingEmail string
+	DisplayName  string
+	Locale       string
+	Updated      time.Time
+}
+
+// ProfileUpdate - изменения профиля, nil - поле не меняется.
+type ProfileUpdate struct {
+	Email       *string
+	DisplayName *string
+	Locale      *string
+}
+
+// EmailToken - содержимое подписанной ссылки подтверждения адреса.
+type EmailToken struct {
+	UserID  string
+	Email   string
+	Expires time.Time
+}
+
+type PasswordReset struct {
 	ID      string
 	UserID  string
 	Expires time.Time
 }
+
+// OIDCState - данные начатого входа через OpenID Connect, хранятся в cookie браузера.
+type OIDCState struct {
+	State    string
+	Nonce    string
+	Verifier string
+	Expires  time.Time
+}
+
+// Identity - учетная запись внешнего провайдера, связанная с пользователем.
+type Identity struct {
+	Issuer  string
+	Subject string
+	UserID  string
+	Created time.Time
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"yp-diploma/internal/app/config"
 	"yp-diploma/internal/app/util"
@@ -11,39 +13,58 @@ import (
 
 type loginVerifyer interface {
 	VerifySessionKey(ctx context.Context, key string) (string, error)
+	VerifyAPIKey(ctx context.Context, key string) (string, []string, error)
+	VerifyAdmin(ctx context.Context, userID string) error
 }
 
+// способ аутентификации запроса, от него зависит необходимость проверки CSRF
+const (
+	authCookie = "cookie"
+	authBearer = "bearer"
+	authAPIKey = "apikey"
+)
+
 type LoginHandler struct {
-	keyName string
-	lv      loginVerifyer
+	keyName     string
+	legacyUntil time.Time
+	csrf        bool
+	cfg         *config.Config
+	lv          loginVerifyer
 }
 
-func NewLoginHandler(keyName string, lv loginVerifyer) *LoginHandler {
+func NewLoginHandler(keyName string, cfg *config.Config, lv loginVerifyer) *LoginHandler {
 	return &LoginHandler{
-		keyName: keyName,
-		lv:      lv,
+		keyName:     keyName,
+		legacyUntil: cfg.LegacyTokenUntil,
+		csrf:        cfg.CSRFProtection,
+		cfg:         cfg,
+		lv:          lv,
 	}
 }
 
+// SetLegacyUntil задает момент, после которого токены старого формата не принимаются.
+// Вызывается при запуске до начала обработки запросов.
+func (lh *LoginHandler) SetLegacyUntil(until time.Time) {
+	lh.legacyUntil = until
+}
+
 func (lh *LoginHandler) AuthUser(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		usercookie, err := r.Cookie(lh.keyName)
-		if err != nil {
-			http.Error(w, "Unautorized", http.StatusUnauthorized)
+		if apiKey := r.Header.Get(config.APIKeyHeader); apiKey != "" {
+			lh.authAPIKey(w, r, next, apiKey)
 			return
 		}
 
-		cryptKey := usercookie.Value
-		signKey, err := util.DecodeString(cryptKey)
-		if err != nil {
-			log.Printf("cookie damaged: %v\n", err)
-			w.WriteHeader(http.StatusInternalServerError)
+		token, method, ok := lh.tokenFromRequest(r)
+		if !ok {
+			http.Error(w, "Unautorized", http.StatusUnauthorized)
 			return
 		}
-		sessKey, ok := util.UnsignString(signKey)
-		if !ok {
-			log.Println("sign damaged")
-			w.WriteHeader(http.StatusInternalServerError)
+
+		sessKey, err := lh.sessionFromToken(token)
+		if err != nil {
+			log.Printf("session token rejected: %v\n", err)
+			http.Error(w, "Unautorized", http.StatusUnauthorized)
 			return
 		}
 
@@ -54,8 +75,73 @@ func (lh *LoginHandler) AuthUser(next http.Handler) http.Handler {
 			return
 		}
 		ctx := context.WithValue(r.Context(), config.ContextKeyUserID, key)
+		ctx = context.WithValue(ctx, config.ContextKeySessionID, sessKey)
+		ctx = context.WithValue(ctx, config.ContextKeyAuthMethod, method)
 		log.Printf("User id: %s\n", key)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 	return http.HandlerFunc(fn)
 }
+
+// RequireAdmin пропускает только пользователей с ролью администратора.
+// Ставится после AuthUser.
+func (lh *LoginHandler) RequireAdmin(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(config.ContextKeyUserID).(string)
+		err := lh.lv.VerifyAdmin(r.Context(), userID)
+		if err != nil {
+			log.Printf("admin access denied for %s: %v\n", userID, err)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// authAPIKey аутентифицирует машинного клиента по API-ключу. Области доступа ключа
+// кладутся в контекст и проверяются RequireScope.
+func (lh *LoginHandler) authAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, apiKey string) {
+	userID, scopes, err := lh.lv.VerifyAPIKey(r.Context(), apiKey)
+	if err != nil {
+		log.Println("error verifying api key: ", err)
+		http.Error(w, "Unautorized", http.StatusUnauthorized)
+		return
+	}
+	ctx := context.WithValue(r.Context(), config.ContextKeyUserID, userID)
+	ctx = context.WithValue(ctx, config.ContextKeyScopes, scopes)
+	ctx = context.WithValue(ctx, config.ContextKeyAuthMethod, authAPIKey)
+	log.Printf("User id: %s (api key)\n", userID)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// tokenFromRequest берет токен из заголовка Authorization: Bearer, а при его отсутствии - из cookie.
+func (lh *LoginHandler) tokenFromRequest(r *http.Request) (string, string, bool) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return "", "", false
+		}
+		token = strings.TrimSpace(token)
+		return token, authBearer, token != ""
+	}
+	usercookie, err := r.Cookie(lh.keyName)
+	if err != nil {
+		return "", "", false
+	}
+	return usercookie.Value, authCookie, true
+}
+
+func (lh *LoginHandler) sessionFromToken(token string) (string, error) {
+	if !util.IsLegacyToken(token) {
+		sessToken, err := util.DecodeToken(token)
+		if err != nil {
+			return "", err
+		}
+		return sessToken.SessionID, nil
+	}
+	if time.Now().After(lh.legacyUntil) {
+		return "", config.ErrLegacyToken
+	}
+	return util.DecodeLegacyToken(token)
+}
@@ -0,0 +1,89 @@
+package mware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/util"
+)
+
+// CSRF проверяет изменяющие запросы, аутентифицированные cookie: заголовок X-CSRF-Token
+// должен совпадать с токеном, выданным в cookie CSRF-TOKEN вместе с сессией (double submit).
+// Запросы с Bearer-токеном и API-ключом браузер сам не подставляет, они не проверяются.
+// Клиенты API, работающие только с cookie сессии и не получавшие CSRF-TOKEN (в том числе
+// сессии, выданные до включения проверки), проверяются, только если запрос пришел с другого сайта.
+// Если cookie CSRF-TOKEN нет, она выдается в ответе. Ставится после AuthUser.
+func (lh *LoginHandler) CSRF(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		method, _ := r.Context().Value(config.ContextKeyAuthMethod).(string)
+		if !lh.csrf || method != authCookie {
+			next.ServeHTTP(w, r)
+			return
+		}
+		usercookie, err := r.Cookie(lh.keyName)
+		if err != nil {
+			http.Error(w, "Unautorized", http.StatusUnauthorized)
+			return
+		}
+		expected := util.CSRFToken(usercookie.Value)
+		_, err = r.Cookie(config.CSRFCookieName)
+		hasCSRFCookie := err == nil
+		if !hasCSRFCookie {
+			http.SetCookie(w, lh.csrfCookie(expected))
+		}
+		if isSafeMethod(r.Method) || (!hasCSRFCookie && !isCrossSite(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		got := r.Header.Get(config.CSRFHeader)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(got)) != 1 {
+			http.Error(w, "invalid csrf token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (lh *LoginHandler) csrfCookie(token string) *http.Cookie {
+	sameSite, _ := lh.cfg.SameSite()
+	return &http.Cookie{
+		Name:     config.CSRFCookieName,
+		Value:    token,
+		Path:     "/",
+		Domain:   lh.cfg.CookieDomain,
+		Secure:   lh.cfg.CookieSecure,
+		SameSite: sameSite,
+	}
+}
+
+// isCrossSite - запрос отправлен страницей другого сайта (по Sec-Fetch-Site или Origin).
+func isCrossSite(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "cross-site", "same-site":
+		return true
+	case "same-origin", "none":
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		return origin == "null"
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return true
+	}
+	return !strings.EqualFold(u.Host, r.Host)
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
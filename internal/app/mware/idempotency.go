@@ -0,0 +1,144 @@
+package mware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+)
+
+const maxIdempotencyKeyLen = 255
+
+type idempotencyStore interface {
+	BeginIdempotent(ctx context.Context, key, requestHash string) (model.IdempotentResponse, bool, error)
+	FinishIdempotent(ctx context.Context, resp model.IdempotentResponse) error
+	AbortIdempotent(ctx context.Context, resp model.IdempotentResponse) error
+}
+
+// ответ сохраняется без контекста запроса: клиент, не дождавшийся ответа, отменяет его,
+// а ключ не должен остаться занятым
+const idempotencySaveTimeout = 5 * time.Second
+
+type IdempotencyHandler struct {
+	store idempotencyStore
+}
+
+func NewIdempotencyHandler(store idempotencyStore) *IdempotencyHandler {
+	return &IdempotencyHandler{store: store}
+}
+
+// recorder передает ответ клиенту и одновременно запоминает его для повторов
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(buf []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(buf)
+	return rec.ResponseWriter.Write(buf)
+}
+
+// Idempotent обрабатывает заголовок Idempotency-Key. Первый ответ на запрос с ключом сохраняется,
+// повтор с тем же телом получает сохраненный ответ, повтор с другим телом - 422.
+// Ответы 5xx не сохраняются, такой запрос можно повторить с тем же ключом. Ставится после AuthUser.
+func (ih *IdempotencyHandler) Idempotent(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(config.IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			http.Error(w, "idempotency key too long", http.StatusBadRequest)
+			return
+		}
+		buf, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(buf))
+
+		sum := sha256.New()
+		sum.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		sum.Write(buf)
+		stored, replay, err := ih.store.BeginIdempotent(r.Context(), key, hex.EncodeToString(sum.Sum(nil)))
+		if err != nil {
+			switch err {
+			case config.ErrIdempotencyMismatch:
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			case config.ErrIdempotencyInProgress:
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				log.Printf("error checking idempotency key:\n error: %s", err)
+			}
+			return
+		}
+		if replay {
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		defer func() {
+			// при панике ключ освобождается, саму панику обрабатывает middleware.Recoverer
+			if p := recover(); p != nil {
+				ih.abort(stored)
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		// ответ уже отправлен, ошибки сохранения только логируются
+		if rec.status >= http.StatusInternalServerError {
+			ih.abort(stored)
+			return
+		}
+		stored.Status = rec.status
+		stored.ContentType = w.Header().Get("Content-Type")
+		stored.Body = rec.body.Bytes()
+		ctx, cancel := context.WithTimeout(context.Background(), idempotencySaveTimeout)
+		defer cancel()
+		err = ih.store.FinishIdempotent(ctx, stored)
+		if err != nil {
+			log.Printf("error saving idempotent response for key %s: %v", key, err)
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (ih *IdempotencyHandler) abort(stored model.IdempotentResponse) {
+	ctx, cancel := context.WithTimeout(context.Background(), idempotencySaveTimeout)
+	defer cancel()
+	err := ih.store.AbortIdempotent(ctx, stored)
+	if err != nil {
+		log.Printf("error releasing idempotency key %s: %v", stored.Key, err)
+	}
+}
@@ -0,0 +1,29 @@
+package mware
+
+import (
+	"net/http"
+
+	"yp-diploma/internal/app/config"
+)
+
+// RequireScope пропускает запрос, если у API-ключа есть область доступа scope.
+// Запросы с сессией пользователя ограничений по областям не имеют.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			scopes, ok := r.Context().Value(config.ContextKeyScopes).([]string)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, s := range scopes {
+				if s == scope {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "insufficient scope", http.StatusForbidden)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
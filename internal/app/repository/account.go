@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	getUserSessions = `SELECT id, user_id, COALESCE(family_id::text, ''), expires, COALESCE(max_expires, expires)
+		FROM session_keys WHERE user_id = $1 ORDER BY expires;`
+	getUserAdjustments = "SELECT user_id, admin_id, amount, reason, regdate FROM balance_adjustments WHERE user_id = $1 ORDER BY regdate;"
+	delUserAttempts    = "DELETE FROM login_attempts WHERE key = 'user:' || (SELECT name FROM users WHERE id = $1);"
+	delUserAPIKeys     = "DELETE FROM api_keys WHERE user_id = $1;"
+	delUserSessKeys    = "DELETE FROM session_keys WHERE user_id = $1;"
+	delUserRefresh     = "DELETE FROM refresh_tokens WHERE user_id = $1;"
+	anonymiseUser      = "UPDATE users SET name = $2, passwd = '', locked = TRUE, deleted = $3 WHERE id = $1 AND deleted IS NULL;"
+)
+
+func (r *Repository) GetUserSessions(ctx context.Context, userID string) ([]model.SessKey, error) {
+	res := make([]model.SessKey, 0)
+	rows, err := r.pool.Query(ctx, getUserSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rec := model.SessKey{}
+		err := rows.Scan(&rec.ID, &rec.UserID, &rec.FamilyID, &rec.Expires, &rec.MaxExpires)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rec)
+	}
+	return res, rows.Err()
+}
+
+func (r *Repository) GetUserAdjustments(ctx context.Context, userID string) ([]model.Adjustment, error) {
+	res := make([]model.Adjustment, 0)
+	rows, err := r.pool.Query(ctx, getUserAdjustments, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rec := model.Adjustment{}
+		err := rows.Scan(&rec.UserID, &rec.AdminID, &rec.Amount, &rec.Reason, &rec.GenTime)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rec)
+	}
+	return res, rows.Err()
+}
+
+// CloseAccount закрывает учетную запись: отзывает все сессии, удаляет ключи, профиль,
+// второй фактор и счетчики входа, а имя и пароль заменяет. Заказы, списания и корректировки остаются
+// привязанными к id пользователя, чтобы не нарушать учет.
+func (r *Repository) CloseAccount(ctx context.Context, userID, anonName string, closed time.Time) error {
+	return r.revoke(ctx, model.RevokeUser, userID, func(tx pgx.Tx) error {
+		for _, stmt := range []string{delUserAttempts, delUserAPIKeys, delProfile, delRecoveryCodes, delTOTP,
+			delUserChallenges, delUserResets, delUserIdentities, delUserSessKeys, delUserRefresh} {
+			_, err := tx.Exec(ctx, stmt, userID)
+			if err != nil {
+				return err
+			}
+		}
+		tag, err := tx.Exec(ctx, anonymiseUser, userID, anonName, closed)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return config.ErrNoSuchRecord
+		}
+		return nil
+	})
+}
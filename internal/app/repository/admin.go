@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	searchUsers = `SELECT id, name, passwd, role, locked FROM users
+		WHERE name ILIKE '%' || $1 || '%' OR id::text = $1 ORDER BY name LIMIT $2;`
+	setUserLocked = "UPDATE users SET locked = $2 WHERE id = $1;"
+	setAdmins     = "UPDATE users SET role = 'admin' WHERE name = ANY($1) AND role <> 'admin' RETURNING name;"
+	addAdjustment = "INSERT INTO balance_adjustments (user_id, admin_id, amount, reason, regdate) VALUES ($1, $2, $3, $4, $5) RETURNING id;"
+	addAudit      = "INSERT INTO admin_audit (admin_id, action, target_id, details, regdate) VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5);"
+)
+
+func (r *Repository) SearchUsers(ctx context.Context, query string, limit int) ([]model.User, error) {
+	res := make([]model.User, 0)
+	rows, err := r.pool.Query(ctx, searchUsers, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rec := model.User{}
+		err := rows.Scan(&rec.ID, &rec.Name, &rec.HashedPasswd, &rec.Role, &rec.Locked)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rec)
+	}
+	return res, nil
+}
+
+func (r *Repository) SetUserLocked(ctx context.Context, userID string, locked bool) error {
+	tag, err := r.pool.Exec(ctx, setUserLocked, userID, locked)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return config.ErrNoSuchRecord
+	}
+	return nil
+}
+
+// SetAdmins назначает роль администратора существующим пользователям с указанными именами
+// и возвращает имена тех, кому роль назначена впервые.
+func (r *Repository) SetAdmins(ctx context.Context, names []string) ([]string, error) {
+	res := make([]string, 0)
+	rows, err := r.pool.Query(ctx, setAdmins, names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, name)
+	}
+	return res, rows.Err()
+}
+
+// AddAdjustment сохраняет корректировку и проводит ее по журналу баллов.
+// Отрицательная корректировка не может увести баланс в минус.
+func (r *Repository) AddAdjustment(ctx context.Context, adj model.Adjustment) error {
+	return r.inTx(ctx, func(tx pgx.Tx) error {
+		var id int64
+		err := tx.QueryRow(ctx, addAdjustment, adj.UserID, adj.AdminID, adj.Amount, adj.Reason, adj.GenTime).Scan(&id)
+		if err != nil {
+			return err
+		}
+		e := &model.LedgerEntry{
+			UserID:  adj.UserID,
+			Kind:    model.LedgerAdjustment,
+			Debit:   model.AccountAdjustments,
+			Credit:  model.AccountUser,
+			Amount:  adj.Amount,
+			Ref:     strconv.FormatInt(id, 10),
+			GenTime: adj.GenTime,
+		}
+		if adj.Amount < 0 {
+			e.Debit, e.Credit, e.Amount = model.AccountUser, model.AccountAdjustments, -adj.Amount
+		}
+		return postEntry(ctx, tx, e)
+	})
+}
+
+func (r *Repository) AddAuditRecord(ctx context.Context, rec model.AuditRecord) error {
+	_, err := r.pool.Exec(ctx, addAudit, rec.AdminID, rec.Action, rec.TargetID, rec.Details, rec.GenTime)
+	return err
+}
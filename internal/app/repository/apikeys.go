@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	addAPIKey      = "INSERT INTO api_keys (id, user_id, name, secret, scopes, created, expires) VALUES ($1, $2, $3, $4, $5, $6, $7);"
+	getAPIKey      = "SELECT id, user_id, name, secret, scopes, created, expires, last_used FROM api_keys WHERE id = $1;"
+	getUserAPIKeys = "SELECT id, user_id, name, secret, scopes, created, expires, last_used FROM api_keys WHERE user_id = $1 ORDER BY created;"
+	touchAPIKey    = "UPDATE api_keys SET last_used = NOW() WHERE id = $1;"
+	delAPIKey      = "DELETE FROM api_keys WHERE id = $1 AND user_id = $2;"
+)
+
+func (r *Repository) AddAPIKey(ctx context.Context, key model.APIKey) error {
+	var expires *time.Time
+	if !key.Expires.IsZero() {
+		expires = &key.Expires
+	}
+	_, err := r.pool.Exec(ctx, addAPIKey, key.ID, key.UserID, key.Name, key.Secret, key.Scopes, key.Created, expires)
+	return err
+}
+
+func (r *Repository) GetAPIKey(ctx context.Context, id string) (model.APIKey, error) {
+	row := r.pool.QueryRow(ctx, getAPIKey, id)
+	res, err := scanAPIKey(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return res, config.ErrNoSuchRecord
+		}
+		return res, err
+	}
+	return res, nil
+}
+
+func (r *Repository) GetUserAPIKeys(ctx context.Context, userID string) ([]model.APIKey, error) {
+	res := make([]model.APIKey, 0)
+	rows, err := r.pool.Query(ctx, getUserAPIKeys, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rec, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rec)
+	}
+	return res, nil
+}
+
+func (r *Repository) TouchAPIKey(ctx context.Context, id string) error {
+	_, err := r.pool.Exec(ctx, touchAPIKey, id)
+	return err
+}
+
+func (r *Repository) DeleteAPIKey(ctx context.Context, userID, id string) error {
+	tag, err := r.pool.Exec(ctx, delAPIKey, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return config.ErrNoSuchRecord
+	}
+	return nil
+}
+
+// неограниченный срок действия и отсутствие обращений хранятся как NULL, в модели - нулевое время
+func scanAPIKey(row pgx.Row) (model.APIKey, error) {
+	var res model.APIKey
+	var expires, lastUsed *time.Time
+	err := row.Scan(&res.ID, &res.UserID, &res.Name, &res.Secret, &res.Scopes, &res.Created, &expires, &lastUsed)
+	if expires != nil {
+		res.Expires = *expires
+	}
+	if lastUsed != nil {
+		res.LastUsed = *lastUsed
+	}
+	return res, err
+}
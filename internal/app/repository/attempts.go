@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	getLoginAttempt = "SELECT key, failures, last_failure, COALESCE(locked_until, last_failure) FROM login_attempts WHERE key = $1;"
+	addLoginFailure = `INSERT INTO login_attempts (key, failures, last_failure) VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure < $2 THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure = NOW()
+		RETURNING key, failures, last_failure, COALESCE(locked_until, last_failure);`
+	setLoginLock       = "UPDATE login_attempts SET locked_until = $2 WHERE key = $1;"
+	resetLoginAttempts = "DELETE FROM login_attempts WHERE key = $1;"
+)
+
+func (r *Repository) GetLoginAttempt(ctx context.Context, key string) (model.LoginAttempt, error) {
+	var res model.LoginAttempt
+	row := r.pool.QueryRow(ctx, getLoginAttempt, key)
+	err := row.Scan(&res.Key, &res.Failures, &res.LastFailure, &res.LockedUntil)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return res, config.ErrNoSuchRecord
+		}
+		return res, err
+	}
+	return res, nil
+}
+
+// AddLoginFailure увеличивает счетчик неудачных попыток. Если последняя неудача
+// была раньше windowStart, счетчик начинается заново.
+func (r *Repository) AddLoginFailure(ctx context.Context, key string, windowStart time.Time) (model.LoginAttempt, error) {
+	var res model.LoginAttempt
+	row := r.pool.QueryRow(ctx, addLoginFailure, key, windowStart)
+	err := row.Scan(&res.Key, &res.Failures, &res.LastFailure, &res.LockedUntil)
+	return res, err
+}
+
+func (r *Repository) SetLoginLock(ctx context.Context, key string, until time.Time) error {
+	_, err := r.pool.Exec(ctx, setLoginLock, key, until)
+	return err
+}
+
+func (r *Repository) ResetLoginAttempts(ctx context.Context, key string) error {
+	_, err := r.pool.Exec(ctx, resetLoginAttempts, key)
+	return err
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Баллы расходуются в порядке начисления (FIFO) по партиям point_lots, поэтому сгорает
+// неизрасходованный остаток партий, начисленных не позже cutoff.
+const (
+	getExpirable      = "SELECT COALESCE(SUM(remaining), 0) FROM point_lots WHERE user_id = $2 AND remaining > 0 AND created <= $1;"
+	getExpirableUsers = "SELECT DISTINCT user_id FROM point_lots WHERE remaining > 0 AND created <= $1 LIMIT $2;"
+	lockBalance       = "SELECT balance FROM user_balances WHERE user_id = $1 FOR UPDATE;"
+)
+
+// ExpirablePoints возвращает количество баллов пользователя, начисленных не позже cutoff
+// и еще не израсходованных.
+func (r *Repository) ExpirablePoints(ctx context.Context, userID string, cutoff time.Time) (int, error) {
+	var res int
+	err := r.pool.QueryRow(ctx, getExpirable, cutoff, userID).Scan(&res)
+	return res, err
+}
+
+// GetUsersWithExpiredPoints возвращает до limit пользователей с баллами, начисленными не позже cutoff.
+func (r *Repository) GetUsersWithExpiredPoints(ctx context.Context, cutoff time.Time, limit int) ([]string, error) {
+	res := make([]string, 0)
+	rows, err := r.pool.Query(ctx, getExpirableUsers, cutoff, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, id)
+	}
+	return res, rows.Err()
+}
+
+// ExpirePoints списывает проводкой сгорания баллы пользователя, начисленные не позже cutoff.
+// Строка баланса блокируется до расчета, чтобы параллельное списание не израсходовало те же баллы.
+// Проводка расходует самые старые партии, то есть именно сгорающие.
+func (r *Repository) ExpirePoints(ctx context.Context, userID string, cutoff time.Time) (int, error) {
+	var due int
+	err := r.inTx(ctx, func(tx pgx.Tx) error {
+		var balance int
+		err := tx.QueryRow(ctx, lockBalance, userID).Scan(&balance)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRow(ctx, getExpirable, cutoff, userID).Scan(&due)
+		if err != nil || due == 0 {
+			return err
+		}
+		return postEntry(ctx, tx, &model.LedgerEntry{
+			UserID:  userID,
+			Kind:    model.LedgerExpiry,
+			Debit:   model.AccountUser,
+			Credit:  model.AccountExpiry,
+			Amount:  due,
+			Ref:     cutoff.UTC().Format(time.RFC3339),
+			GenTime: time.Now(),
+		})
+	})
+	return due, err
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// orderLockClass - пространство advisory-блокировок номеров заказов. Резерв и списание
+// проверяют номер в чужой таблице, поэтому общего ограничения уникальности у них нет
+// и параллельные запросы с одним номером сериализуются блокировкой.
+const orderLockClass = 7410
+
+const (
+	lockOrder         = "SELECT pg_advisory_xact_lock($1, hashtext($2));"
+	withdrawOrderUsed = "SELECT EXISTS (SELECT 1 FROM withdraws WHERE order_id = $1 AND NOT legacy_duplicate);"
+	holdOrderUsed     = "SELECT EXISTS (SELECT 1 FROM point_holds WHERE order_id = $1 AND status = 'HELD');"
+	addHold           = "INSERT INTO point_holds (id, user_id, order_id, amount, created, expires) VALUES ($1, $2, $3, $4, $5, $6);"
+	lockHold          = `SELECT id, user_id, order_id, amount, status, created, expires FROM point_holds
+		WHERE id = $1 FOR UPDATE;`
+	settleHold   = "UPDATE point_holds SET status = $2, settled = $3 WHERE id = $1;"
+	getUserHolds = `SELECT id, user_id, order_id, amount, status, created, expires FROM point_holds
+		WHERE user_id = $1 AND status = 'HELD' ORDER BY created;`
+	getExpiredHolds = "SELECT id FROM point_holds WHERE status = 'HELD' AND expires < $1 ORDER BY expires LIMIT $2;"
+)
+
+// AddHold резервирует баллы под заказ. Номер заказа не должен быть занят списанием
+// или другим действующим резервом, иначе возвращается ErrWithdrawOrderUsed.
+func (r *Repository) AddHold(ctx context.Context, h model.Hold) error {
+	return r.inTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, lockOrder, orderLockClass, h.OrderID)
+		if err != nil {
+			return err
+		}
+		var used bool
+		err = tx.QueryRow(ctx, withdrawOrderUsed, h.OrderID).Scan(&used)
+		if err != nil {
+			return err
+		}
+		if used {
+			return config.ErrWithdrawOrderUsed
+		}
+		_, err = tx.Exec(ctx, addHold, h.ID, h.UserID, h.OrderID, h.Amount, h.GenTime, h.Expires)
+		if err != nil {
+			var pgerr *pgconn.PgError
+			if errors.As(err, &pgerr) && strings.EqualFold(pgerr.ConstraintName, "point_holds_order_idx") {
+				return config.ErrWithdrawOrderUsed
+			}
+			return err
+		}
+		return postEntry(ctx, tx, &model.LedgerEntry{
+			UserID:  h.UserID,
+			Kind:    model.LedgerHold,
+			Debit:   model.AccountUser,
+			Credit:  model.AccountHolds,
+			Amount:  h.Amount,
+			Ref:     h.ID,
+			GenTime: h.GenTime,
+		})
+	})
+}
+
+// SettleHold переводит действующий резерв в состояние status. При HoldCaptured записывается
+// списание по номеру заказа резерва, в остальных случаях баллы возвращаются на баланс.
+// check вызывается для заблокированной строки резерва и может отменить операцию.
+func (r *Repository) SettleHold(ctx context.Context, holdID, status string, check func(h model.Hold) error) (model.Hold, error) {
+	var res model.Hold
+	err := r.inTx(ctx, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, lockHold, holdID)
+		err := row.Scan(&res.ID, &res.UserID, &res.OrderID, &res.Amount, &res.Status, &res.GenTime, &res.Expires)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return config.ErrNoSuchRecord
+			}
+			return err
+		}
+		err = check(res)
+		if err != nil {
+			return err
+		}
+		if res.Status != model.HoldActive {
+			return config.ErrHoldSettled
+		}
+		now := time.Now()
+		_, err = tx.Exec(ctx, settleHold, holdID, status, now)
+		if err != nil {
+			return err
+		}
+		res.Status = status
+		if status != model.HoldCaptured {
+			return postEntry(ctx, tx, &model.LedgerEntry{
+				UserID:  res.UserID,
+				Kind:    model.LedgerRelease,
+				Debit:   model.AccountHolds,
+				Credit:  model.AccountUser,
+				Amount:  res.Amount,
+				Ref:     res.ID,
+				GenTime: now,
+			})
+		}
+		_, err = tx.Exec(ctx, addWithdraw, res.OrderID, res.UserID, now, res.Amount)
+		if err != nil {
+			var pgerr *pgconn.PgError
+			if errors.As(err, &pgerr) && strings.EqualFold(pgerr.ConstraintName, "withdraws_order_idx") {
+				return config.ErrWithdrawOrderUsed
+			}
+			return err
+		}
+		return postEntry(ctx, tx, &model.LedgerEntry{
+			UserID:  res.UserID,
+			Kind:    model.LedgerCapture,
+			Debit:   model.AccountHolds,
+			Credit:  model.AccountWithdrawals,
+			Amount:  res.Amount,
+			Ref:     res.OrderID,
+			GenTime: now,
+		})
+	})
+	return res, err
+}
+
+// GetUserHolds возвращает действующие резервы пользователя.
+func (r *Repository) GetUserHolds(ctx context.Context, userID string) ([]model.Hold, error) {
+	res := make([]model.Hold, 0)
+	rows, err := r.pool.Query(ctx, getUserHolds, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rec := model.Hold{}
+		err := rows.Scan(&rec.ID, &rec.UserID, &rec.OrderID, &rec.Amount, &rec.Status, &rec.GenTime, &rec.Expires)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rec)
+	}
+	return res, rows.Err()
+}
+
+// GetExpiredHolds возвращает до limit действующих резервов со сроком до before.
+func (r *Repository) GetExpiredHolds(ctx context.Context, before time.Time, limit int) ([]string, error) {
+	res := make([]string, 0)
+	rows, err := r.pool.Query(ctx, getExpiredHolds, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, id)
+	}
+	return res, rows.Err()
+}
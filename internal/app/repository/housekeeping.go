@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	purgeSessKeys      = "DELETE FROM session_keys WHERE expires < NOW();"
+	purgeRefreshTokens = "DELETE FROM refresh_tokens WHERE expires < NOW();"
+	purgeChallenges    = "DELETE FROM login_challenges WHERE expires < NOW();"
+	purgeResets        = "DELETE FROM password_resets WHERE expires < NOW();"
+	purgeLoginAttempts = "DELETE FROM login_attempts WHERE last_failure < $1 AND (locked_until IS NULL OR locked_until < NOW());"
+	// события, не нужные для учета: использованные коды восстановления и завершенные резервы
+	// (списание по резерву хранится в withdraws, движение баллов - в журнале)
+	purgeRecoveryCodes = "DELETE FROM totp_recovery_codes WHERE used < $1;"
+	purgeSettledHolds  = "DELETE FROM point_holds WHERE status <> 'HELD' AND settled < $1;"
+)
+
+// PurgeExpiredSessions удаляет просроченные сессии, refresh-токены, запросы второго фактора
+// и токены сброса пароля.
+func (r *Repository) PurgeExpiredSessions(ctx context.Context) (int64, error) {
+	var total int64
+	for _, stmt := range []string{purgeSessKeys, purgeRefreshTokens, purgeChallenges, purgeResets} {
+		tag, err := r.pool.Exec(ctx, stmt)
+		if err != nil {
+			return total, err
+		}
+		total += tag.RowsAffected()
+	}
+	return total, nil
+}
+
+// PurgeLoginAttempts удаляет счетчики неудачных входов, не обновлявшиеся с before
+// и не держащие действующую блокировку.
+func (r *Repository) PurgeLoginAttempts(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.pool.Exec(ctx, purgeLoginAttempts, before)
+	return tag.RowsAffected(), err
+}
+
+// VacuumEvents удаляет служебные события старше before. Журнал аудита администраторов
+// и журнал баллов не очищаются.
+func (r *Repository) VacuumEvents(ctx context.Context, before time.Time) (int64, error) {
+	var total int64
+	for _, stmt := range []string{purgeRecoveryCodes, purgeSettledHolds} {
+		tag, err := r.pool.Exec(ctx, stmt, before)
+		if err != nil {
+			return total, err
+		}
+		total += tag.RowsAffected()
+	}
+	return total, nil
+}
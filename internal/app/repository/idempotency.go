@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// ключ занимается, если его нет, сохраненный ответ старше retention или обработка
+	// запроса, занявшего ключ, не завершилась за время аренды (процесс упал)
+	claimIdempotency = `INSERT INTO idempotency_keys (user_id, key, request_hash, created) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, key) DO UPDATE SET request_hash = $3, status = NULL, content_type = '', body = NULL, created = $4
+			WHERE idempotency_keys.created < $5 OR (idempotency_keys.status IS NULL AND idempotency_keys.created < $6)
+		RETURNING key;`
+	getIdempotency = `SELECT user_id, key, request_hash, COALESCE(status, 0), content_type, COALESCE(body, ''::bytea), created
+		FROM idempotency_keys WHERE user_id = $1 AND key = $2;`
+	// ответ сохраняется и ключ освобождается только запросом, который его занял: после истечения
+	// аренды ключ может быть занят заново, и запись другого запроса трогать нельзя
+	saveIdempotency = `UPDATE idempotency_keys SET status = $5, content_type = $6, body = $7
+		WHERE user_id = $1 AND key = $2 AND request_hash = $3 AND created = $4 AND status IS NULL;`
+	delIdempotency = `DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2 AND request_hash = $3 AND created = $4 AND status IS NULL;`
+	purgeIdempotency = "DELETE FROM idempotency_keys WHERE created < $1;"
+)
+
+// ClaimIdempotencyKey занимает ключ для нового запроса. Записи с ответом старше before и
+// незавершенные записи старше leaseBefore занимаются заново. Если ключ уже занят действующей
+// записью, возвращается она и false.
+func (r *Repository) ClaimIdempotencyKey(ctx context.Context, rec model.IdempotentResponse, before, leaseBefore time.Time) (model.IdempotentResponse, bool, error) {
+	var key string
+	err := r.pool.QueryRow(ctx, claimIdempotency, rec.UserID, rec.Key, rec.RequestHash, rec.Created, before, leaseBefore).Scan(&key)
+	if err == nil {
+		return rec, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return rec, false, err
+	}
+	var res model.IdempotentResponse
+	row := r.pool.QueryRow(ctx, getIdempotency, rec.UserID, rec.Key)
+	err = row.Scan(&res.UserID, &res.Key, &res.RequestHash, &res.Status, &res.ContentType, &res.Body, &res.Created)
+	return res, false, err
+}
+
+func (r *Repository) SaveIdempotentResponse(ctx context.Context, rec model.IdempotentResponse) error {
+	_, err := r.pool.Exec(ctx, saveIdempotency, rec.UserID, rec.Key, rec.RequestHash, rec.Created,
+		rec.Status, rec.ContentType, rec.Body)
+	return err
+}
+
+// DeleteIdempotencyKey освобождает ключ, занятый запросом rec.
+func (r *Repository) DeleteIdempotencyKey(ctx context.Context, rec model.IdempotentResponse) error {
+	_, err := r.pool.Exec(ctx, delIdempotency, rec.UserID, rec.Key, rec.RequestHash, rec.Created)
+	return err
+}
+
+func (r *Repository) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.pool.Exec(ctx, purgeIdempotency, before)
+	return tag.RowsAffected(), err
+}
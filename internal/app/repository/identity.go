@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	getIdentityUser = `SELECT u.id, u.name, u.passwd, u.role, u.locked FROM users u
+		JOIN user_identities i ON i.user_id = u.id WHERE i.issuer = $1 AND i.subject = $2 AND u.deleted IS NULL;`
+	addIdentity       = "INSERT INTO user_identities (issuer, subject, user_id, created) VALUES ($1, $2, $3, $4);"
+	delUserIdentities = "DELETE FROM user_identities WHERE user_id = $1;"
+)
+
+func (r *Repository) GetIdentityUser(ctx context.Context, issuer, subject string) (model.User, error) {
+	var res model.User
+	row := r.pool.QueryRow(ctx, getIdentityUser, issuer, subject)
+	err := row.Scan(&res.ID, &res.Name, &res.HashedPasswd, &res.Role, &res.Locked)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return res, config.ErrNoSuchRecord
+		}
+		return res, err
+	}
+	return res, nil
+}
+
+func (r *Repository) AddIdentity(ctx context.Context, id model.Identity) error {
+	_, err := r.pool.Exec(ctx, addIdentity, id.Issuer, id.Subject, id.UserID, id.Created)
+	return err
+}
+
+// AddUserWithIdentity создает пользователя, пришедшего от внешнего провайдера, вместе со связью.
+func (r *Repository) AddUserWithIdentity(ctx context.Context, user model.User, id model.Identity) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var pgerr *pgconn.PgError
+	_, err = tx.Exec(ctx, addUser, user.ID, user.Name, user.HashedPasswd)
+	if err != nil {
+		if errors.As(err, &pgerr) && strings.EqualFold(pgerr.ConstraintName, "users_name_key") {
+			return config.ErrUserNameBusy
+		}
+		return err
+	}
+	_, err = tx.Exec(ctx, addIdentity, id.Issuer, id.Subject, user.ID, id.Created)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
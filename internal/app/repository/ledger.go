@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	postBalance = `INSERT INTO user_balances (user_id, balance, accrued, withdrawn, held, updated) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE SET balance = user_balances.balance + $2,
+			accrued = user_balances.accrued + $3, withdrawn = user_balances.withdrawn + $4,
+			held = user_balances.held + $5, updated = $6
+		RETURNING balance;`
+	addLedgerEntry = `INSERT INTO ledger_entries (user_id, kind, debit, credit, amount, ref, balance, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id;`
+	getLedgerEntries = `SELECT id, user_id, kind, debit, credit, amount, ref, balance, created FROM ledger_entries
+		WHERE user_id = $1 AND ($2::text[] IS NULL OR kind = ANY($2))
+			AND ($3::timestamptz IS NULL OR created >= $3) AND ($4::timestamptz IS NULL OR created < $4)
+			AND id > $5
+		ORDER BY id LIMIT $6;`
+	addLot = "INSERT INTO point_lots (user_id, amount, remaining, created) VALUES ($1, $2, $2, $3);"
+	// списание расходует самые старые партии
+	consumeLots = `WITH c AS (SELECT id, remaining, SUM(remaining) OVER (ORDER BY created, id) AS cum
+			FROM point_lots WHERE user_id = $1 AND remaining > 0)
+		UPDATE point_lots p SET remaining = CASE WHEN c.cum <= $2 THEN 0 ELSE c.cum - $2 END
+		FROM c WHERE p.id = c.id AND c.cum - c.remaining < $2;`
+	// перевод расходует самые старые партии отправителя ($1) и открывает получателю ($2)
+	// партии с теми же датами начисления, чтобы переводы не продлевали срок баллов
+	moveLots = `WITH c AS (SELECT id, remaining, SUM(remaining) OVER (ORDER BY created, id) AS cum
+			FROM point_lots WHERE user_id = $1 AND remaining > 0),
+		u AS (UPDATE point_lots p SET remaining = CASE WHEN c.cum <= $3 THEN 0 ELSE c.cum - $3 END
+			FROM c WHERE p.id = c.id AND c.cum - c.remaining < $3
+			RETURNING p.created, c.remaining - p.remaining AS moved)
+		INSERT INTO point_lots (user_id, amount, remaining, created)
+		SELECT $2, moved, moved, created FROM u WHERE moved > 0;`
+	// возврат восполняет партии, израсходованные последними
+	refillLots = `WITH c AS (SELECT id, amount - remaining AS gap,
+				SUM(amount - remaining) OVER (ORDER BY created DESC, id DESC) AS cum
+			FROM point_lots WHERE user_id = $1 AND remaining < amount)
+		UPDATE point_lots p SET remaining = p.remaining + CASE WHEN c.cum <= $2 THEN c.gap ELSE c.gap - (c.cum - $2) END
+		FROM c WHERE p.id = c.id AND c.cum - c.gap < $2;`
+	// баланс по последней проводке до момента $2, итоги - как в ledgerTotals
+	getBalanceAt = `SELECT COALESCE((SELECT balance FROM ledger_entries WHERE user_id = $1 AND created <= $2
+				ORDER BY id DESC LIMIT 1), 0),
+			COALESCE(SUM(amount) FILTER (WHERE kind = 'accrual'), 0),
+			COALESCE(SUM(CASE kind WHEN 'withdrawal' THEN amount WHEN 'capture' THEN amount WHEN 'reversal' THEN -amount END), 0),
+			COALESCE(SUM(CASE kind WHEN 'hold' THEN amount WHEN 'capture' THEN -amount WHEN 'release' THEN -amount END), 0)
+		FROM ledger_entries WHERE user_id = $1 AND created <= $2;`
+)
+
+// inTx выполняет exec в транзакции.
+func (r *Repository) inTx(ctx context.Context, exec func(tx pgx.Tx) error) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = exec(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// postEntry добавляет проводку в журнал и изменяет баланс пользователя в транзакции tx.
+// Обновление строки user_balances блокирует ее до конца транзакции, поэтому проводки
+// одного пользователя выполняются по очереди. Баланс не может стать отрицательным,
+// в этом случае возвращается ErrNotEnoughAccruals.
+func postEntry(ctx context.Context, tx pgx.Tx, e *model.LedgerEntry) error {
+	if e.Amount == 0 {
+		return nil
+	}
+	accrued, withdrawn, held := ledgerTotals(e)
+	err := tx.QueryRow(ctx, postBalance, e.UserID, e.Delta(), accrued, withdrawn, held, e.GenTime).Scan(&e.Balance)
+	if err != nil {
+		var pgerr *pgconn.PgError
+		if errors.As(err, &pgerr) && pgerr.ConstraintName == "user_balances_nonnegative" {
+			return config.ErrNotEnoughAccruals
+		}
+		return err
+	}
+	err = updateLots(ctx, tx, e)
+	if err != nil {
+		return err
+	}
+	return tx.QueryRow(ctx, addLedgerEntry, e.UserID, e.Kind, e.Debit, e.Credit, e.Amount, e.Ref,
+		e.Balance, e.GenTime).Scan(&e.ID)
+}
+
+// updateLots изменяет партии баллов пользователя для сгорания. Вызывается после обновления
+// user_balances, блокировка строки баланса защищает партии от параллельных изменений.
+// Партии при переводе переносит AddTransfer (moveLots).
+func updateLots(ctx context.Context, tx pgx.Tx, e *model.LedgerEntry) error {
+	var err error
+	switch {
+	case e.Kind == model.LedgerTransferOut || e.Kind == model.LedgerTransferIn:
+	case e.Credit == model.AccountUser && isReturn(e.Kind):
+		_, err = tx.Exec(ctx, refillLots, e.UserID, e.Amount)
+	case e.Credit == model.AccountUser:
+		_, err = tx.Exec(ctx, addLot, e.UserID, e.Amount, e.GenTime)
+	case e.Debit == model.AccountUser:
+		_, err = tx.Exec(ctx, consumeLots, e.UserID, e.Amount)
+	}
+	return err
+}
+
+// isReturn - проводка возвращает ранее списанные баллы (сторно, отмена резерва).
+func isReturn(kind string) bool {
+	return kind == model.LedgerReversal || kind == model.LedgerRelease
+}
+
+// GetLedgerEntries возвращает проводки пользователя по условиям q.
+func (r *Repository) GetLedgerEntries(ctx context.Context, q model.LedgerQuery) ([]model.LedgerEntry, error) {
+	res := make([]model.LedgerEntry, 0)
+	var kinds []string
+	if len(q.Kinds) > 0 {
+		kinds = q.Kinds
+	}
+	rows, err := r.pool.Query(ctx, getLedgerEntries, q.UserID, kinds, nullTime(q.From), nullTime(q.To),
+		q.AfterID, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rec := model.LedgerEntry{}
+		err := rows.Scan(&rec.ID, &rec.UserID, &rec.Kind, &rec.Debit, &rec.Credit, &rec.Amount, &rec.Ref,
+			&rec.Balance, &rec.GenTime)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rec)
+	}
+	return res, rows.Err()
+}
+
+// GetBalanceAt восстанавливает баланс пользователя на момент at по журналу баллов.
+func (r *Repository) GetBalanceAt(ctx context.Context, userID string, at time.Time) (model.Balance, error) {
+	res := model.Balance{UserID: userID}
+	row := r.pool.QueryRow(ctx, getBalanceAt, userID, at)
+	err := row.Scan(&res.Balance, &res.Accrual, &res.Withdraw, &res.Held)
+	return res, err
+}
+
+// nullTime - NULL в запросе для нулевого времени.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// ledgerTotals - изменение сумм начисленных, списанных и зарезервированных баллов в ответе о балансе
+func ledgerTotals(e *model.LedgerEntry) (int, int, int) {
+	switch e.Kind {
+	case model.LedgerAccrual:
+		return e.Amount, 0, 0
+	case model.LedgerWithdrawal:
+		return 0, e.Amount, 0
+	case model.LedgerReversal:
+		return 0, -e.Amount, 0
+	case model.LedgerHold:
+		return 0, 0, e.Amount
+	case model.LedgerCapture:
+		return 0, e.Amount, -e.Amount
+	case model.LedgerRelease:
+		return 0, 0, -e.Amount
+	default:
+		return 0, 0, 0
+	}
+}
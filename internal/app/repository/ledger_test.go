@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+	"yp-diploma/internal/app/model"
+	"yp-diploma/internal/app/util"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestLedgerTotals(t *testing.T) {
+	tests := []struct {
+		kind                     string
+		accrued, withdrawn, held int
+	}{
+		{model.LedgerAccrual, 100, 0, 0},
+		{model.LedgerWithdrawal, 0, 100, 0},
+		{model.LedgerReversal, 0, -100, 0},
+		{model.LedgerHold, 0, 0, 100},
+		{model.LedgerCapture, 0, 100, -100},
+		{model.LedgerRelease, 0, 0, -100},
+		{model.LedgerAdjustment, 0, 0, 0},
+		{model.LedgerExpiry, 0, 0, 0},
+		{model.LedgerTransferOut, 0, 0, 0},
+		{model.LedgerTransferIn, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			accrued, withdrawn, held := ledgerTotals(&model.LedgerEntry{Kind: tt.kind, Amount: 100})
+			if accrued != tt.accrued || withdrawn != tt.withdrawn || held != tt.held {
+				t.Errorf("ledgerTotals = (%d, %d, %d), want (%d, %d, %d)",
+					accrued, withdrawn, held, tt.accrued, tt.withdrawn, tt.held)
+			}
+		})
+	}
+}
+
+// TestExpirablePoints проверяет учет партий для сгорания на настоящей базе.
+// Запускается, только если задана TEST_DATABASE_URI; база должна быть отдельной, тестовой.
+func TestExpirablePoints(t *testing.T) {
+	conn := os.Getenv("TEST_DATABASE_URI")
+	if conn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	ctx := context.Background()
+	r := New()
+	err := r.Init(ctx, conn)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-24 * time.Hour)
+	old, recent := now.Add(-48*time.Hour), now.Add(-time.Hour)
+	credit := func(kind, from string, amount int, at time.Time) model.LedgerEntry {
+		return model.LedgerEntry{Kind: kind, Debit: from, Credit: model.AccountUser, Amount: amount, GenTime: at}
+	}
+	debit := func(kind, to string, amount int) model.LedgerEntry {
+		return model.LedgerEntry{Kind: kind, Debit: model.AccountUser, Credit: to, Amount: amount, GenTime: now}
+	}
+	accrual := func(amount int, at time.Time) model.LedgerEntry {
+		return credit(model.LedgerAccrual, model.AccountAccrual, amount, at)
+	}
+
+	tests := []struct {
+		name    string
+		entries []model.LedgerEntry
+		want    int
+	}{
+		{"unspent", []model.LedgerEntry{
+			accrual(100, old), accrual(50, recent),
+		}, 100},
+		{"withdrawal spends oldest", []model.LedgerEntry{
+			accrual(100, old), accrual(50, recent),
+			debit(model.LedgerWithdrawal, model.AccountWithdrawals, 60),
+		}, 40},
+		{"cancelled withdrawal", []model.LedgerEntry{
+			accrual(100, old), debit(model.LedgerWithdrawal, model.AccountWithdrawals, 60), accrual(50, recent),
+			credit(model.LedgerReversal, model.AccountWithdrawals, 60, now),
+		}, 100},
+		{"refund refills latest spent lots", []model.LedgerEntry{
+			accrual(100, old), accrual(100, recent),
+			debit(model.LedgerWithdrawal, model.AccountWithdrawals, 150),
+			debit(model.LedgerWithdrawal, model.AccountWithdrawals, 30),
+			credit(model.LedgerReversal, model.AccountWithdrawals, 30, now),
+		}, 0},
+		{"refund of a withdrawal spanning lots", []model.LedgerEntry{
+			accrual(100, old), accrual(100, recent),
+			debit(model.LedgerWithdrawal, model.AccountWithdrawals, 150),
+			credit(model.LedgerReversal, model.AccountWithdrawals, 150, now),
+		}, 100},
+		{"released hold", []model.LedgerEntry{
+			accrual(100, old), debit(model.LedgerHold, model.AccountHolds, 80),
+			credit(model.LedgerRelease, model.AccountHolds, 80, now),
+		}, 100},
+		{"active hold", []model.LedgerEntry{
+			accrual(100, old), debit(model.LedgerHold, model.AccountHolds, 80),
+		}, 20},
+		{"negative adjustment", []model.LedgerEntry{
+			accrual(100, old), accrual(100, recent),
+			debit(model.LedgerAdjustment, model.AccountAdjustments, 120),
+		}, 0},
+		{"positive adjustment", []model.LedgerEntry{
+			credit(model.LedgerAdjustment, model.AccountAdjustments, 70, old),
+			debit(model.LedgerWithdrawal, model.AccountWithdrawals, 20),
+		}, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := addTestUser(ctx, t, r)
+			postTestEntries(ctx, t, r, userID, tt.entries)
+			got, err := r.ExpirablePoints(ctx, userID, cutoff)
+			if err != nil {
+				t.Fatalf("ExpirablePoints: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ExpirablePoints = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("expire", func(t *testing.T) {
+		userID := addTestUser(ctx, t, r)
+		postTestEntries(ctx, t, r, userID, []model.LedgerEntry{
+			accrual(100, old), accrual(50, recent),
+			debit(model.LedgerWithdrawal, model.AccountWithdrawals, 30),
+		})
+		due, err := r.ExpirePoints(ctx, userID, cutoff)
+		if err != nil || due != 70 {
+			t.Fatalf("ExpirePoints = %d, %v, want 70", due, err)
+		}
+		due, err = r.ExpirePoints(ctx, userID, cutoff)
+		if err != nil || due != 0 {
+			t.Fatalf("repeated ExpirePoints = %d, %v, want 0", due, err)
+		}
+		got, err := r.ExpirablePoints(ctx, userID, now)
+		if err != nil || got != 50 {
+			t.Errorf("ExpirablePoints after expiry = %d, %v, want 50", got, err)
+		}
+	})
+}
+
+// TestTransferKeepsLotDates проверяет, что переведенные баллы сгорают в срок отправителя.
+func TestTransferKeepsLotDates(t *testing.T) {
+	conn := os.Getenv("TEST_DATABASE_URI")
+	if conn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	ctx := context.Background()
+	r := New()
+	err := r.Init(ctx, conn)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-24 * time.Hour)
+	sender, recipient := addTestUser(ctx, t, r), addTestUser(ctx, t, r)
+	postTestEntries(ctx, t, r, sender, []model.LedgerEntry{
+		{Kind: model.LedgerAccrual, Debit: model.AccountAccrual, Credit: model.AccountUser, Amount: 100, GenTime: now.Add(-48 * time.Hour)},
+		{Kind: model.LedgerAccrual, Debit: model.AccountAccrual, Credit: model.AccountUser, Amount: 100, GenTime: now.Add(-time.Hour)},
+	})
+	// получатель сразу переводит баллы обратно, срок сгорания от этого не меняется
+	for _, tr := range []model.Transfer{
+		{SenderID: sender, RecipientID: recipient, Amount: 150, GenTime: now},
+		{SenderID: recipient, RecipientID: sender, Amount: 150, GenTime: now},
+	} {
+		tr := tr
+		err = r.AddTransfer(ctx, &tr, now.Add(-24*time.Hour), 0)
+		if err != nil {
+			t.Fatalf("AddTransfer: %v", err)
+		}
+	}
+	for _, tt := range []struct {
+		userID string
+		want   int
+	}{{sender, 100}, {recipient, 0}} {
+		got, err := r.ExpirablePoints(ctx, tt.userID, cutoff)
+		if err != nil || got != tt.want {
+			t.Errorf("ExpirablePoints = %d, %v, want %d", got, err, tt.want)
+		}
+	}
+}
+
+func addTestUser(ctx context.Context, t *testing.T, r *Repository) string {
+	t.Helper()
+	id, err := util.GetRandHexString(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.AddUser(ctx, model.User{ID: id, Name: "lots-" + id[:12], HashedPasswd: "-"})
+	if err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+	return id
+}
+
+func postTestEntries(ctx context.Context, t *testing.T, r *Repository, userID string, entries []model.LedgerEntry) {
+	t.Helper()
+	for _, e := range entries {
+		e := e
+		e.UserID = userID
+		e.Ref = "test"
+		err := r.inTx(ctx, func(tx pgx.Tx) error {
+			return postEntry(ctx, tx, &e)
+		})
+		if err != nil {
+			t.Fatalf("posting %s: %v", e.Kind, err)
+		}
+	}
+}
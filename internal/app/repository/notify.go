@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SessionRevokedChannel - канал LISTEN/NOTIFY, по которому экземпляры сервиса
+// узнают об отзыве сессий. Сообщение имеет вид "<вид>:<id>", см. model.Revoke*.
+const SessionRevokedChannel = "session_revoked"
+
+const (
+	notifyRevoked = "SELECT pg_notify('" + SessionRevokedChannel + "', $1);"
+	listenRevoked = "LISTEN " + SessionRevokedChannel + ";"
+)
+
+// revoke выполняет отзыв в транзакции и рассылает уведомление. Уведомление
+// доставляется слушателям только после фиксации транзакции.
+func (r *Repository) revoke(ctx context.Context, kind, id string, exec func(tx pgx.Tx) error) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = exec(tx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, notifyRevoked, kind+":"+id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ListenRevocations забирает соединение из пула и передает в handler уведомления об отзыве
+// сессий, пока не отменен контекст или не оборвалось соединение.
+func (r *Repository) ListenRevocations(ctx context.Context, handler func(payload string)) error {
+	pconn, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// соединение с активным LISTEN не возвращается в пул
+	conn := pconn.Hijack()
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, listenRevoked)
+	if err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		handler(n.Payload)
+	}
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	getProfile = `SELECT user_id, COALESCE(email, ''), COALESCE(pending_email, ''), display_name, locale, updated
+		FROM user_profiles WHERE user_id = $1;`
+	saveProfile = `INSERT INTO user_profiles (user_id, email, pending_email, display_name, locale, updated)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE SET email = NULLIF($2, ''), pending_email = NULLIF($3, ''),
+			display_name = $4, locale = $5, updated = $6;`
+	verifyEmail = `UPDATE user_profiles SET email = pending_email, pending_email = NULL, updated = $3
+		WHERE user_id = $1 AND lower(pending_email) = lower($2);`
+	delProfile = "DELETE FROM user_profiles WHERE user_id = $1;"
+)
+
+func (r *Repository) GetProfile(ctx context.Context, userID string) (model.Profile, error) {
+	var res model.Profile
+	row := r.pool.QueryRow(ctx, getProfile, userID)
+	err := row.Scan(&res.UserID, &res.Email, &res.PendingEmail, &res.DisplayName, &res.Locale, &res.Updated)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return res, config.ErrNoSuchRecord
+		}
+		return res, err
+	}
+	return res, nil
+}
+
+func (r *Repository) SaveProfile(ctx context.Context, p model.Profile) error {
+	_, err := r.pool.Exec(ctx, saveProfile, p.UserID, p.Email, p.PendingEmail, p.DisplayName, p.Locale, p.Updated)
+	return emailError(err)
+}
+
+// VerifyEmail делает ожидающий подтверждения адрес основным. Если ожидающий адрес
+// с тех пор изменился, возвращается ErrInvalidToken.
+func (r *Repository) VerifyEmail(ctx context.Context, userID, email string) error {
+	tag, err := r.pool.Exec(ctx, verifyEmail, userID, email, time.Now())
+	if err != nil {
+		return emailError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return config.ErrInvalidToken
+	}
+	return nil
+}
+
+// подтвержденный адрес может принадлежать только одному пользователю
+func emailError(err error) error {
+	var pgerr *pgconn.PgError
+	if errors.As(err, &pgerr) && strings.EqualFold(pgerr.ConstraintName, "user_profiles_email_idx") {
+		return config.ErrEmailBusy
+	}
+	return err
+}
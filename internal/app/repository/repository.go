@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"yp-diploma/internal/app/config"
 	"yp-diploma/internal/app/model"
@@ -47,38 +48,319 @@ const (
 		withdraw	INT NOT NULL		
 	);
 
-	CREATE OR REPLACE VIEW balances AS 
-		 (SELECT o.user_id, 
-			 o.asum, 
-			 w.wsum, 
-			 (COALESCE(o.asum, 0) - COALESCE(w.wsum, 0)) bal 
-		    FROM (SELECT user_id, 
-				 		sum(accrual) asum
-			        FROM orders
-			        GROUP BY user_id) AS o
-		    	LEFT JOIN 
-			 	(SELECT user_id, 
-				        sum(withdraw) wsum
-				   FROM withdraws 
-			       GROUP BY user_id) AS w 
-	        	ON o.user_id = w.user_id);
-
-	/* erase old session keys */				
-	DELETE FROM session_keys WHERE expires < NOW();
+	/* sessions with sliding expiry, grouped into refresh families */
+	ALTER TABLE session_keys ADD COLUMN IF NOT EXISTS family_id uuid;
+	ALTER TABLE session_keys ADD COLUMN IF NOT EXISTS max_expires TIMESTAMP;
+	CREATE INDEX IF NOT EXISTS session_keys_family_idx ON session_keys (family_id);
+
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id			CHAR(64) NOT NULL CONSTRAINT refresh_pk PRIMARY KEY,
+		family_id	uuid	 NOT NULL,
+		user_id		uuid 	 NOT NULL REFERENCES users,
+		expires		TIMESTAMP WITH TIME ZONE NOT NULL,
+		used		TIMESTAMP WITH TIME ZONE
+	);
+	CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family_id);
+	/* note: refresh_tokens.used is set once the token is exchanged,
+			 a second exchange of the same token revokes the whole family
+	*/
+
+	/* failed login counters, key is "user:<name>" or "ip:<addr>" */
+	CREATE TABLE IF NOT EXISTS login_attempts (
+		key				VARCHAR(80) NOT NULL CONSTRAINT login_attempts_pk PRIMARY KEY,
+		failures		INT NOT NULL,
+		last_failure	TIMESTAMP WITH TIME ZONE NOT NULL,
+		locked_until	TIMESTAMP WITH TIME ZONE
+	);
+
+	/* TOTP second factor, secret is encrypted with the server key */
+	CREATE TABLE IF NOT EXISTS user_totp (
+		user_id		uuid	NOT NULL CONSTRAINT user_totp_pk PRIMARY KEY REFERENCES users,
+		secret		TEXT	NOT NULL,
+		confirmed	BOOLEAN NOT NULL DEFAULT FALSE,
+		last_step	BIGINT	NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+		user_id		uuid	 NOT NULL REFERENCES users,
+		code		CHAR(64) NOT NULL,
+		used		TIMESTAMP WITH TIME ZONE,
+		CONSTRAINT totp_recovery_pk PRIMARY KEY (user_id, code)
+	);
+
+	/* pending logins waiting for the second factor */
+	CREATE TABLE IF NOT EXISTS login_challenges (
+		id			CHAR(64) NOT NULL CONSTRAINT login_challenges_pk PRIMARY KEY,
+		user_id		uuid	 NOT NULL REFERENCES users,
+		expires		TIMESTAMP WITH TIME ZONE NOT NULL,
+		attempts	INT NOT NULL DEFAULT 0
+	);
+
+	/* API keys for machine clients, only the secret hash is stored */
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id			CHAR(16) NOT NULL CONSTRAINT api_keys_pk PRIMARY KEY,
+		user_id		uuid	 NOT NULL REFERENCES users,
+		name		VARCHAR(64) NOT NULL,
+		secret		CHAR(64) NOT NULL,
+		scopes		TEXT[]	 NOT NULL,
+		created		TIMESTAMP WITH TIME ZONE NOT NULL,
+		expires		TIMESTAMP WITH TIME ZONE,
+		last_used	TIMESTAMP WITH TIME ZONE
+	);
+	CREATE INDEX IF NOT EXISTS api_keys_user_idx ON api_keys (user_id);
+
+	/* roles and administrative locks */
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'user';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS locked BOOLEAN NOT NULL DEFAULT FALSE;
+
+	/* manual balance corrections made by admins, amount may be negative */
+	CREATE TABLE IF NOT EXISTS balance_adjustments (
+		id			BIGSERIAL NOT NULL CONSTRAINT balance_adjustments_pk PRIMARY KEY,
+		user_id		uuid 	 NOT NULL REFERENCES users,
+		admin_id	uuid 	 NOT NULL REFERENCES users,
+		amount		INT NOT NULL,
+		reason		TEXT NOT NULL,
+		regdate		TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS balance_adjustments_user_idx ON balance_adjustments (user_id);
+
+	CREATE TABLE IF NOT EXISTS admin_audit (
+		id			BIGSERIAL NOT NULL CONSTRAINT admin_audit_pk PRIMARY KEY,
+		admin_id	uuid 	 NOT NULL REFERENCES users,
+		action		VARCHAR(32) NOT NULL,
+		target_id	uuid,
+		details		TEXT NOT NULL,
+		regdate		TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
+	/* closed accounts keep their id for accounting, personal fields are anonymised */
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted TIMESTAMP WITH TIME ZONE;
+
+	/* contact details, email holds only a verified address */
+	CREATE TABLE IF NOT EXISTS user_profiles (
+		user_id			uuid	NOT NULL CONSTRAINT user_profiles_pk PRIMARY KEY REFERENCES users,
+		email			VARCHAR(254),
+		pending_email	VARCHAR(254),
+		display_name	VARCHAR(64) NOT NULL DEFAULT '',
+		locale			VARCHAR(16) NOT NULL DEFAULT '',
+		updated			TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS user_profiles_email_idx ON user_profiles (lower(email));
+
+	/* single-use password reset tokens, only the token hash is stored */
+	CREATE TABLE IF NOT EXISTS password_resets (
+		id			CHAR(64) NOT NULL CONSTRAINT password_resets_pk PRIMARY KEY,
+		user_id		uuid	 NOT NULL REFERENCES users,
+		expires		TIMESTAMP WITH TIME ZONE NOT NULL,
+		used		TIMESTAMP WITH TIME ZONE
+	);
+	CREATE INDEX IF NOT EXISTS password_resets_user_idx ON password_resets (user_id);
+
+	/* accounts of external OpenID Connect providers linked to users */
+	CREATE TABLE IF NOT EXISTS user_identities (
+		issuer		VARCHAR(255) NOT NULL,
+		subject		VARCHAR(255) NOT NULL,
+		user_id		uuid	 NOT NULL REFERENCES users,
+		created		TIMESTAMP WITH TIME ZONE NOT NULL,
+		CONSTRAINT user_identities_pk PRIMARY KEY (issuer, subject)
+	);
+	CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities (user_id);
+
+	/* stored responses of mutating requests sent with an Idempotency-Key header,
+	   status is NULL while the first request is still being processed */
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id			uuid	 NOT NULL REFERENCES users,
+		key				VARCHAR(255) NOT NULL,
+		request_hash	CHAR(64) NOT NULL,
+		status			INT,
+		content_type	VARCHAR(255) NOT NULL DEFAULT '',
+		body			BYTEA,
+		created			TIMESTAMP WITH TIME ZONE NOT NULL,
+		CONSTRAINT idempotency_keys_pk PRIMARY KEY (user_id, key)
+	);
+
+	/* values fixed on the first start with this database */
+	CREATE TABLE IF NOT EXISTS app_settings (
+		name		VARCHAR(64) NOT NULL CONSTRAINT app_settings_pk PRIMARY KEY,
+		value		TEXT NOT NULL
+	);
+
+	/* one-off data migrations, applied once per database */
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		name		VARCHAR(64) NOT NULL CONSTRAINT schema_migrations_pk PRIMARY KEY,
+		applied		TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
+	/* append-only points ledger, every entry moves amount from the debit account
+	   to the credit account. "user" is the points account of user_id, other
+	   accounts are system ones. balance is the user balance after the entry */
+	CREATE TABLE IF NOT EXISTS ledger_entries (
+		id			BIGSERIAL NOT NULL CONSTRAINT ledger_entries_pk PRIMARY KEY,
+		user_id		uuid 	 NOT NULL REFERENCES users,
+		kind		VARCHAR(16) NOT NULL,
+		debit		VARCHAR(32) NOT NULL,
+		credit		VARCHAR(32) NOT NULL,
+		amount		BIGINT NOT NULL CHECK (amount > 0),
+		ref			VARCHAR(64) NOT NULL DEFAULT '',
+		balance		BIGINT NOT NULL,
+		created		TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS ledger_entries_user_idx ON ledger_entries (user_id, created, id);
+	CREATE INDEX IF NOT EXISTS ledger_entries_user_id_idx ON ledger_entries (user_id, id);
+
+	/* balances maintained in the same transaction as each ledger entry */
+	CREATE TABLE IF NOT EXISTS user_balances (
+		user_id		uuid 	 NOT NULL CONSTRAINT user_balances_pk PRIMARY KEY REFERENCES users,
+		balance		BIGINT NOT NULL CONSTRAINT user_balances_nonnegative CHECK (balance >= 0),
+		accrued		BIGINT NOT NULL DEFAULT 0,
+		withdrawn	BIGINT NOT NULL DEFAULT 0,
+		updated		TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
+	/* users overdrawn before balances were checked in one transaction */
+	CREATE TABLE IF NOT EXISTS legacy_overdrafts (
+		user_id		uuid 	 NOT NULL CONSTRAINT legacy_overdrafts_pk PRIMARY KEY REFERENCES users,
+		amount		BIGINT NOT NULL,
+		recorded	TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
+	/* seed the ledger and balances from orders, withdrawals and adjustments
+	   recorded before the ledger existed */
+	INSERT INTO ledger_entries (user_id, kind, debit, credit, amount, ref, balance, created)
+		SELECT user_id, kind, debit, credit, amount, ref,
+			   SUM(CASE WHEN credit = 'user' THEN amount ELSE -amount END)
+				   OVER (PARTITION BY user_id ORDER BY created, ord, ref ROWS UNBOUNDED PRECEDING),
+			   created
+		  FROM (SELECT user_id, 'accrual' kind, 'system:accrual' debit, 'user' credit,
+					   accrual amount, id ref, regdate created, 1 ord
+				  FROM orders WHERE accrual > 0
+				UNION ALL
+				SELECT user_id, 'withdrawal', 'user', 'system:withdrawals',
+					   withdraw, order_id, regdate, 2
+				  FROM withdraws WHERE withdraw > 0
+				UNION ALL
+				SELECT user_id, 'adjustment',
+					   CASE WHEN amount > 0 THEN 'system:adjustments' ELSE 'user' END,
+					   CASE WHEN amount > 0 THEN 'user' ELSE 'system:adjustments' END,
+					   abs(amount), id::text, regdate, 3
+				  FROM balance_adjustments WHERE amount <> 0) AS m
+		 WHERE NOT EXISTS (SELECT 1 FROM schema_migrations WHERE name = 'ledger')
+		 ORDER BY created, ord, ref;
+	/* a negative legacy balance would violate user_balances_nonnegative: it is
+	   recorded in legacy_overdrafts and closed by an adjustment to zero */
+	INSERT INTO legacy_overdrafts (user_id, amount, recorded)
+		SELECT user_id, -SUM(CASE WHEN credit = 'user' THEN amount ELSE -amount END), NOW()
+		  FROM ledger_entries
+		 WHERE NOT EXISTS (SELECT 1 FROM schema_migrations WHERE name = 'ledger')
+		 GROUP BY user_id
+		HAVING SUM(CASE WHEN credit = 'user' THEN amount ELSE -amount END) < 0;
+	INSERT INTO ledger_entries (user_id, kind, debit, credit, amount, ref, balance, created)
+		SELECT user_id, 'adjustment', 'system:adjustments', 'user', amount, 'legacy_overdraft', 0, recorded
+		  FROM legacy_overdrafts
+		 WHERE NOT EXISTS (SELECT 1 FROM schema_migrations WHERE name = 'ledger');
+	INSERT INTO user_balances (user_id, balance, accrued, withdrawn, updated)
+		SELECT user_id,
+			   SUM(CASE WHEN credit = 'user' THEN amount ELSE -amount END),
+			   SUM(CASE WHEN kind = 'accrual' THEN amount ELSE 0 END),
+			   SUM(CASE WHEN kind = 'withdrawal' THEN amount ELSE 0 END),
+			   NOW()
+		  FROM ledger_entries
+		 WHERE NOT EXISTS (SELECT 1 FROM schema_migrations WHERE name = 'ledger')
+		 GROUP BY user_id;
+	INSERT INTO schema_migrations (name, applied) VALUES ('ledger', NOW()) ON CONFLICT DO NOTHING;
+	DROP VIEW IF EXISTS balances;
+
+	/* an order number may be used for one withdrawal only, duplicates recorded
+	   before the rule are kept and flagged, the earliest one stays the valid one */
+	ALTER TABLE withdraws ADD COLUMN IF NOT EXISTS id BIGSERIAL;
+	ALTER TABLE withdraws ADD COLUMN IF NOT EXISTS legacy_duplicate BOOLEAN NOT NULL DEFAULT FALSE;
+	UPDATE withdraws SET legacy_duplicate = TRUE
+	 WHERE id NOT IN (SELECT DISTINCT ON (order_id) id FROM withdraws ORDER BY order_id, regdate, id)
+	   AND NOT EXISTS (SELECT 1 FROM schema_migrations WHERE name = 'withdraws_order_unique');
+	INSERT INTO schema_migrations (name, applied) VALUES ('withdraws_order_unique', NOW()) ON CONFLICT DO NOTHING;
+	CREATE UNIQUE INDEX IF NOT EXISTS withdraws_order_idx ON withdraws (order_id) WHERE NOT legacy_duplicate;
+
+	/* cancelled and refunded withdrawals stay in place, points return by a reversal entry */
+	ALTER TABLE withdraws ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'DONE';
+	ALTER TABLE withdraws ADD COLUMN IF NOT EXISTS reversed TIMESTAMP WITH TIME ZONE;
+
+	/* points reserved for a pending purchase, captured into a withdrawal or released */
+	ALTER TABLE user_balances ADD COLUMN IF NOT EXISTS held BIGINT NOT NULL DEFAULT 0;
+	CREATE TABLE IF NOT EXISTS point_holds (
+		id			uuid 	 NOT NULL CONSTRAINT point_holds_pk PRIMARY KEY,
+		user_id		uuid 	 NOT NULL REFERENCES users,
+		order_id	VARCHAR(64) NOT NULL,
+		amount		BIGINT NOT NULL CHECK (amount > 0),
+		status		VARCHAR(16) NOT NULL DEFAULT 'HELD',
+		created		TIMESTAMP WITH TIME ZONE NOT NULL,
+		expires		TIMESTAMP WITH TIME ZONE NOT NULL,
+		settled		TIMESTAMP WITH TIME ZONE
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS point_holds_order_idx ON point_holds (order_id) WHERE status = 'HELD';
+	CREATE INDEX IF NOT EXISTS point_holds_user_idx ON point_holds (user_id, created);
+	CREATE INDEX IF NOT EXISTS point_holds_expires_idx ON point_holds (expires) WHERE status = 'HELD';
+
+	/* points moved between users, each transfer posts an entry to both ledgers */
+	CREATE TABLE IF NOT EXISTS point_transfers (
+		id			BIGSERIAL NOT NULL CONSTRAINT point_transfers_pk PRIMARY KEY,
+		sender_id	uuid 	 NOT NULL REFERENCES users,
+		recipient_id uuid 	 NOT NULL REFERENCES users,
+		amount		BIGINT NOT NULL CHECK (amount > 0),
+		regdate		TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS point_transfers_sender_idx ON point_transfers (sender_id, regdate);
+	CREATE INDEX IF NOT EXISTS point_transfers_recipient_idx ON point_transfers (recipient_id, regdate);
+
+	/* lots of credited points for FIFO expiry: every credit except returns opens a lot,
+	   debits consume the oldest lots, returns refill the most recently consumed ones.
+	   The sum of remaining equals the user balance */
+	CREATE TABLE IF NOT EXISTS point_lots (
+		id			BIGSERIAL NOT NULL CONSTRAINT point_lots_pk PRIMARY KEY,
+		user_id		uuid 	 NOT NULL REFERENCES users,
+		amount		BIGINT NOT NULL CHECK (amount > 0),
+		remaining	BIGINT NOT NULL CHECK (remaining >= 0 AND remaining <= amount),
+		created		TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS point_lots_user_idx ON point_lots (user_id, created, id);
+	CREATE INDEX IF NOT EXISTS point_lots_unspent_idx ON point_lots (created) WHERE remaining > 0;
+	INSERT INTO point_lots (user_id, amount, remaining, created)
+		SELECT user_id, amount, GREATEST(0, LEAST(amount, cum - consumed)), created
+		  FROM (SELECT e.user_id, e.amount, e.created, c.consumed,
+					   SUM(e.amount) OVER (PARTITION BY e.user_id ORDER BY e.created, e.id) cum
+				  FROM ledger_entries e
+				  JOIN (SELECT user_id,
+							   SUM(CASE WHEN debit = 'user' THEN amount
+										WHEN kind IN ('reversal', 'release') THEN -amount ELSE 0 END) consumed
+						  FROM ledger_entries GROUP BY user_id) c ON c.user_id = e.user_id
+				 WHERE e.credit = 'user' AND e.kind NOT IN ('reversal', 'release')) AS s
+		 WHERE NOT EXISTS (SELECT 1 FROM schema_migrations WHERE name = 'point_lots')
+		 ORDER BY created;
+	INSERT INTO schema_migrations (name, applied) VALUES ('point_lots', NOW()) ON CONFLICT DO NOTHING;
 `
 
 	addUser         = "INSERT INTO users (id, name, passwd) VALUES ($1, $2, $3);"
-	getUser         = "SELECT id, name, passwd FROM users WHERE name=$1;"
-	addSessKey      = "INSERT INTO session_keys (id, user_id, expires) VALUES ($1, $2, $3);"
-	getSessKey      = "SELECT id, user_id, expires FROM session_keys  WHERE id = $1;"
+	getUser         = "SELECT id, name, passwd, role, locked FROM users WHERE name=$1;"
+	getUserByID     = "SELECT id, name, passwd, role, locked FROM users WHERE id=$1;"
+	updatePasswd    = "UPDATE users SET passwd = $2 WHERE id = $1;"
+	addSessKey      = "INSERT INTO session_keys (id, user_id, expires, family_id, max_expires) VALUES ($1, $2, $3, $4, $5);"
+	getSessKey      = "SELECT id, user_id, COALESCE(family_id::text, ''), expires, COALESCE(max_expires, expires) FROM session_keys  WHERE id = $1;"
+	touchSessKey    = "UPDATE session_keys SET expires = LEAST($2, max_expires) WHERE id = $1;"
+	delSessKey      = "DELETE FROM session_keys WHERE id = $1;"
+	delFamilyKeys   = "DELETE FROM session_keys WHERE family_id = $1;"
+	addRefreshToken = "INSERT INTO refresh_tokens (id, family_id, user_id, expires) VALUES ($1, $2, $3, $4);"
+	useRefreshToken = "UPDATE refresh_tokens SET used = NOW() WHERE id = $1 AND used IS NULL RETURNING id, family_id, user_id, expires;"
+	getRefreshToken = "SELECT id, family_id, user_id, expires FROM refresh_tokens WHERE id = $1;"
+	revokeFamily    = "UPDATE refresh_tokens SET used = COALESCE(used, NOW()) WHERE family_id = $1;"
+	delUserKeys     = "DELETE FROM session_keys WHERE user_id = $1 AND ($2 = '' OR family_id IS DISTINCT FROM NULLIF($2, '')::uuid);"
+	revokeUserToken = "UPDATE refresh_tokens SET used = COALESCE(used, NOW()) WHERE user_id = $1 AND ($2 = '' OR family_id IS DISTINCT FROM NULLIF($2, '')::uuid);"
 	addOrder        = "INSERT INTO orders (id, user_id, regdate) VALUES ($1, $2, $3);"
 	getOrder        = "SELECT id, user_id, regdate, COALESCE(accrual, -1) FROM orders WHERE id = $1;"
 	getUserOrders   = "SELECT id, user_id, regdate, COALESCE(accrual, -1) FROM orders WHERE user_id = $1 ORDER BY regdate;"
 	getUndoneOrders = "SELECT id, user_id, regdate, COALESCE(accrual, -1) FROM orders WHERE accrual IS NULL;"
-	updateAccrual   = "UPDATE orders SET accrual = $2 where id = $1;"
-	getBalance      = "SELECT user_id, COALESCE(asum,0), COALESCE(wsum,0), COALESCE(bal,0) FROM balances WHERE user_id = $1;"
+	updateAccrual   = "UPDATE orders SET accrual = $2 WHERE id = $1 AND accrual IS NULL RETURNING user_id;"
+	getBalance      = "SELECT user_id, accrued, withdrawn, balance, held FROM user_balances WHERE user_id = $1;"
 	addWithdraw     = "INSERT INTO withdraws (order_id, user_id, regdate, withdraw) VALUES ($1, $2, $3, $4);"
-	getWithdraws    = "SELECT order_id, user_id, regdate, withdraw FROM withdraws WHERE user_id = $1 ORDER BY regdate;"
+	getWithdraws    = "SELECT id, order_id, user_id, regdate, withdraw, status FROM withdraws WHERE user_id = $1 ORDER BY regdate;"
 )
 
 type Repository struct {
@@ -103,9 +385,20 @@ func (r *Repository) Init(ctx context.Context, conn string) error {
 	return r.initDDL(ctx)
 }
 
+// ddlLockID - ключ advisory-блокировки, под которой выполняется DDL и разовые миграции.
+const ddlLockID = 7410530301
+
+// initDDL создает схему и выполняет разовые миграции в одной транзакции: миграция и ее отметка
+// в schema_migrations фиксируются вместе, а экземпляры, запущенные одновременно, ждут друг друга.
 func (r *Repository) initDDL(ctx context.Context) error {
-	_, err := r.pool.Exec(ctx, DDL)
-	return err
+	return r.inTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1);", int64(ddlLockID))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, DDL)
+		return err
+	})
 }
 
 func (r *Repository) AddUser(ctx context.Context, user model.User) error {
@@ -122,7 +415,7 @@ func (r *Repository) AddUser(ctx context.Context, user model.User) error {
 func (r *Repository) GetUserID(ctx context.Context, name string) (model.User, error) {
 	var res model.User
 	row := r.pool.QueryRow(ctx, getUser, name)
-	err := row.Scan(&res.ID, &res.Name, &res.HashedPasswd)
+	err := row.Scan(&res.ID, &res.Name, &res.HashedPasswd, &res.Role, &res.Locked)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return res, config.ErrNoSuchRecord
@@ -132,18 +425,141 @@ func (r *Repository) GetUserID(ctx context.Context, name string) (model.User, er
 	return res, err
 }
 
+func (r *Repository) GetUserByID(ctx context.Context, userID string) (model.User, error) {
+	var res model.User
+	row := r.pool.QueryRow(ctx, getUserByID, userID)
+	err := row.Scan(&res.ID, &res.Name, &res.HashedPasswd, &res.Role, &res.Locked)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return res, config.ErrNoSuchRecord
+		}
+		return res, err
+	}
+	return res, err
+}
+
+func (r *Repository) UpdatePasswd(ctx context.Context, userID, hashedPasswd string) error {
+	_, err := r.pool.Exec(ctx, updatePasswd, userID, hashedPasswd)
+	return err
+}
+
 func (r *Repository) AddSessKey(ctx context.Context, key model.SessKey) error {
-	_, err := r.pool.Exec(ctx, addSessKey, key.ID, key.UserID, key.Expires)
+	_, err := r.pool.Exec(ctx, addSessKey, key.ID, key.UserID, key.Expires, key.FamilyID, key.MaxExpires)
 	return err
 }
 
 func (r *Repository) GetSessKey(ctx context.Context, key string) (model.SessKey, error) {
 	var res model.SessKey
 	row := r.pool.QueryRow(ctx, getSessKey, key)
-	err := row.Scan(&res.ID, &res.UserID, &res.Expires)
+	err := row.Scan(&res.ID, &res.UserID, &res.FamilyID, &res.Expires, &res.MaxExpires)
 	return res, err
 }
 
+func (r *Repository) TouchSessKey(ctx context.Context, key string, expires time.Time) error {
+	_, err := r.pool.Exec(ctx, touchSessKey, key, expires)
+	return err
+}
+
+func (r *Repository) DeleteSessKey(ctx context.Context, key string) error {
+	return r.revoke(ctx, model.RevokeSession, key, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, delSessKey, key)
+		return err
+	})
+}
+
+func (r *Repository) DeleteFamilySessKeys(ctx context.Context, familyID string) error {
+	return r.revoke(ctx, model.RevokeFamily, familyID, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, delFamilyKeys, familyID)
+		return err
+	})
+}
+
+func (r *Repository) AddRefreshToken(ctx context.Context, token model.RefreshToken) error {
+	_, err := r.pool.Exec(ctx, addRefreshToken, token.ID, token.FamilyID, token.UserID, token.Expires)
+	return err
+}
+
+// RotateRefreshToken в одной транзакции погашает refresh-токен, удаляет сессии его семейства
+// и сохраняет сессию и refresh-токен, подготовленные issue по погашенному токену. Если issue
+// или сохранение завершились ошибкой, токен остается непогашенным. Повторно использованный
+// токен отзывает все семейство (ErrRefreshTokenReused).
+func (r *Repository) RotateRefreshToken(ctx context.Context, id string,
+	issue func(old model.RefreshToken) (model.SessKey, model.RefreshToken, error)) (model.RefreshToken, error) {
+	var old model.RefreshToken
+	err := r.inTx(ctx, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, useRefreshToken, id)
+		err := row.Scan(&old.ID, &old.FamilyID, &old.UserID, &old.Expires)
+		if err != nil {
+			return err
+		}
+		key, rt, err := issue(old)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, delFamilyKeys, old.FamilyID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, addSessKey, key.ID, key.UserID, key.Expires, key.FamilyID, key.MaxExpires)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, addRefreshToken, rt.ID, rt.FamilyID, rt.UserID, rt.Expires)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, notifyRevoked, model.RevokeFamily+":"+old.FamilyID)
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return r.refreshTokenReused(ctx, id)
+	}
+	return old, err
+}
+
+// refreshTokenReused отзывает семейство уже погашенного токена id.
+func (r *Repository) refreshTokenReused(ctx context.Context, id string) (model.RefreshToken, error) {
+	var res model.RefreshToken
+	row := r.pool.QueryRow(ctx, getRefreshToken, id)
+	err := row.Scan(&res.ID, &res.FamilyID, &res.UserID, &res.Expires)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return res, config.ErrNoSuchRecord
+		}
+		return res, err
+	}
+	err = r.RevokeFamily(ctx, res.FamilyID)
+	if err != nil {
+		return res, err
+	}
+	return res, config.ErrRefreshTokenReused
+}
+
+// RevokeUserSessions удаляет все сессии пользователя и погашает его refresh-токены,
+// кроме семейства keepFamily (пустая строка - отозвать все).
+func (r *Repository) RevokeUserSessions(ctx context.Context, userID, keepFamily string) error {
+	return r.revoke(ctx, model.RevokeUser, userID, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, delUserKeys, userID, keepFamily)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, revokeUserToken, userID, keepFamily)
+		return err
+	})
+}
+
+// RevokeFamily удаляет сессии семейства и погашает все его refresh-токены.
+func (r *Repository) RevokeFamily(ctx context.Context, familyID string) error {
+	return r.revoke(ctx, model.RevokeFamily, familyID, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, delFamilyKeys, familyID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, revokeFamily, familyID)
+		return err
+	})
+}
+
 func (r *Repository) AddOrder(ctx context.Context, order model.Order) error {
 	_, err := r.pool.Exec(ctx, addOrder, order.ID, order.UserID, order.GenTime)
 	return err
@@ -207,38 +623,44 @@ func (r *Repository) getOrderList(ctx context.Context, sqlStatement string, para
 	return res, nil
 }
 
+// UpdateAccruals сохраняет окончательные результаты расчета и зачисляет баллы в той же транзакции.
+// Заказ, результат которого уже сохранен, повторно не зачисляется.
 func (r *Repository) UpdateAccruals(ctx context.Context, data []model.Order) error {
-	tx, err := r.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
-
-	btch := &pgx.Batch{}
-
-	for _, rec := range data {
-		btch.Queue(updateAccrual, rec.ID, rec.Accrual)
-	}
-	bres := tx.SendBatch(ctx, btch)
-
-	for range data {
-		_, qerr := bres.Exec()
-		if qerr != nil {
-			return qerr
+	return r.inTx(ctx, func(tx pgx.Tx) error {
+		now := time.Now()
+		for _, rec := range data {
+			var userID string
+			err := tx.QueryRow(ctx, updateAccrual, rec.ID, rec.Accrual).Scan(&userID)
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					continue
+				}
+				return err
+			}
+			if rec.Accrual <= 0 {
+				continue
+			}
+			err = postEntry(ctx, tx, &model.LedgerEntry{
+				UserID:  userID,
+				Kind:    model.LedgerAccrual,
+				Debit:   model.AccountAccrual,
+				Credit:  model.AccountUser,
+				Amount:  rec.Accrual,
+				Ref:     rec.ID,
+				GenTime: now,
+			})
+			if err != nil {
+				return err
+			}
 		}
-	}
-	err = bres.Close()
-	if err != nil {
-		return err
-	}
-
-	return tx.Commit(ctx)
+		return nil
+	})
 }
 
 func (r *Repository) GetBalance(ctx context.Context, userid string) (model.Balance, error) {
 	var res model.Balance
 	row := r.pool.QueryRow(ctx, getBalance, userid)
-	err := row.Scan(&res.UserID, &res.Accrual, &res.Withdraw, &res.Balance)
+	err := row.Scan(&res.UserID, &res.Accrual, &res.Withdraw, &res.Balance, &res.Held)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return res, config.ErrNoSuchRecord
@@ -248,9 +670,41 @@ func (r *Repository) GetBalance(ctx context.Context, userid string) (model.Balan
 	return res, err
 }
 
+// AddWithdraw записывает списание и проводку по счету пользователя в одной транзакции.
+// Номер заказа может использоваться только в одном списании и не должен быть занят действующим резервом,
+// проверка выполняется под блокировкой номера заказа. Если баллов не хватает, ограничение user_balances отклоняет проводку и возвращается ErrNotEnoughAccruals.
 func (r *Repository) AddWithdraw(ctx context.Context, w model.Withdraw) error {
-	_, err := r.pool.Exec(ctx, addWithdraw, w.OrderID, w.UserID, w.GenTime, w.Withdraw)
-	return err
+	return r.inTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, lockOrder, orderLockClass, w.OrderID)
+		if err != nil {
+			return err
+		}
+		var held bool
+		err = tx.QueryRow(ctx, holdOrderUsed, w.OrderID).Scan(&held)
+		if err != nil {
+			return err
+		}
+		if held {
+			return config.ErrWithdrawOrderUsed
+		}
+		var pgerr *pgconn.PgError
+		_, err = tx.Exec(ctx, addWithdraw, w.OrderID, w.UserID, w.GenTime, w.Withdraw)
+		if err != nil {
+			if errors.As(err, &pgerr) && strings.EqualFold(pgerr.ConstraintName, "withdraws_order_idx") {
+				return config.ErrWithdrawOrderUsed
+			}
+			return err
+		}
+		return postEntry(ctx, tx, &model.LedgerEntry{
+			UserID:  w.UserID,
+			Kind:    model.LedgerWithdrawal,
+			Debit:   model.AccountUser,
+			Credit:  model.AccountWithdrawals,
+			Amount:  w.Withdraw,
+			Ref:     w.OrderID,
+			GenTime: w.GenTime,
+		})
+	})
 }
 
 func (r *Repository) GetWithdrawList(ctx context.Context, userID string) ([]model.Withdraw, error) {
@@ -263,7 +717,7 @@ func (r *Repository) GetWithdrawList(ctx context.Context, userID string) ([]mode
 
 	for rows.Next() {
 		rec := model.Withdraw{}
-		err := rows.Scan(&rec.OrderID, &rec.UserID, &rec.GenTime, &rec.Withdraw)
+		err := rows.Scan(&rec.ID, &rec.OrderID, &rec.UserID, &rec.GenTime, &rec.Withdraw, &rec.Status)
 		if err != nil {
 			return nil, err
 		}
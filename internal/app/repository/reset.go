@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	getUserByEmail = `SELECT u.id, u.name, u.passwd, u.role, u.locked FROM users u
+		JOIN user_profiles p ON p.user_id = u.id WHERE lower(p.email) = lower($1) AND u.deleted IS NULL;`
+	addPasswordReset = "INSERT INTO password_resets (id, user_id, expires) VALUES ($1, $2, $3);"
+	usePasswordReset = `UPDATE password_resets SET used = NOW() WHERE id = $1 AND used IS NULL AND expires > NOW()
+		RETURNING id, user_id, expires;`
+	delUserResets = "DELETE FROM password_resets WHERE user_id = $1;"
+)
+
+// GetUserByEmail ищет пользователя по подтвержденному адресу.
+func (r *Repository) GetUserByEmail(ctx context.Context, email string) (model.User, error) {
+	var res model.User
+	row := r.pool.QueryRow(ctx, getUserByEmail, email)
+	err := row.Scan(&res.ID, &res.Name, &res.HashedPasswd, &res.Role, &res.Locked)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return res, config.ErrNoSuchRecord
+		}
+		return res, err
+	}
+	return res, nil
+}
+
+// AddPasswordReset сохраняет новый токен сброса, ранее выданные токены пользователя удаляются.
+func (r *Repository) AddPasswordReset(ctx context.Context, reset model.PasswordReset) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, delUserResets, reset.UserID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, addPasswordReset, reset.ID, reset.UserID, reset.Expires)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// UsePasswordReset погашает действующий токен, повторно он не принимается.
+func (r *Repository) UsePasswordReset(ctx context.Context, id string) (model.PasswordReset, error) {
+	var res model.PasswordReset
+	row := r.pool.QueryRow(ctx, usePasswordReset, id)
+	err := row.Scan(&res.ID, &res.UserID, &res.Expires)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return res, config.ErrInvalidToken
+		}
+		return res, err
+	}
+	return res, nil
+}
+
+func (r *Repository) DeletePasswordResets(ctx context.Context, userID string) error {
+	_, err := r.pool.Exec(ctx, delUserResets, userID)
+	return err
+}
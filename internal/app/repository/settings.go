@@ -0,0 +1,19 @@
+package repository
+
+import "context"
+
+const (
+	initSetting = "INSERT INTO app_settings (name, value) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING;"
+	getSetting  = "SELECT value FROM app_settings WHERE name = $1;"
+)
+
+// InitSetting сохраняет значение настройки, если оно еще не задано, и возвращает действующее.
+func (r *Repository) InitSetting(ctx context.Context, name, value string) (string, error) {
+	_, err := r.pool.Exec(ctx, initSetting, name, value)
+	if err != nil {
+		return "", err
+	}
+	var res string
+	err = r.pool.QueryRow(ctx, getSetting, name).Scan(&res)
+	return res, err
+}
@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	getTOTP = "SELECT user_id, secret, confirmed, last_step FROM user_totp WHERE user_id = $1;"
+	addTOTP = `INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = $2, confirmed = FALSE, last_step = 0
+		WHERE user_totp.confirmed = FALSE;`
+	confirmTOTP       = "UPDATE user_totp SET confirmed = TRUE, last_step = $2 WHERE user_id = $1 AND confirmed = FALSE;"
+	useTOTPStep       = "UPDATE user_totp SET last_step = $2 WHERE user_id = $1 AND last_step < $2;"
+	delTOTP           = "DELETE FROM user_totp WHERE user_id = $1;"
+	delRecoveryCodes  = "DELETE FROM totp_recovery_codes WHERE user_id = $1;"
+	addRecoveryCode   = "INSERT INTO totp_recovery_codes (user_id, code) VALUES ($1, $2);"
+	useRecoveryCode   = "UPDATE totp_recovery_codes SET used = NOW() WHERE user_id = $1 AND code = $2 AND used IS NULL;"
+	addChallenge      = "INSERT INTO login_challenges (id, user_id, expires) VALUES ($1, $2, $3);"
+	incChallenge      = "UPDATE login_challenges SET attempts = attempts + 1 WHERE id = $1 RETURNING id, user_id, expires, attempts;"
+	delChallenge      = "DELETE FROM login_challenges WHERE id = $1;"
+	delUserChallenges = "DELETE FROM login_challenges WHERE user_id = $1;"
+)
+
+func (r *Repository) GetTOTP(ctx context.Context, userID string) (model.TOTP, error) {
+	var res model.TOTP
+	row := r.pool.QueryRow(ctx, getTOTP, userID)
+	err := row.Scan(&res.UserID, &res.Secret, &res.Confirmed, &res.LastStep)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return res, config.ErrNoSuchRecord
+		}
+		return res, err
+	}
+	return res, nil
+}
+
+// AddTOTP сохраняет новый неподтвержденный секрет и коды восстановления.
+// Подключенный (подтвержденный) второй фактор не перезаписывается.
+func (r *Repository) AddTOTP(ctx context.Context, totp model.TOTP, recoveryCodes []string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, addTOTP, totp.UserID, totp.Secret)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return config.ErrTOTPEnabled
+	}
+	_, err = tx.Exec(ctx, delRecoveryCodes, totp.UserID)
+	if err != nil {
+		return err
+	}
+	for _, code := range recoveryCodes {
+		_, err = tx.Exec(ctx, addRecoveryCode, totp.UserID, code)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *Repository) ConfirmTOTP(ctx context.Context, userID string, step int64) error {
+	tag, err := r.pool.Exec(ctx, confirmTOTP, userID, step)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return config.ErrTOTPEnabled
+	}
+	return nil
+}
+
+// UseTOTPStep запоминает использованный временной шаг, чтобы один код нельзя было предъявить дважды.
+func (r *Repository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	tag, err := r.pool.Exec(ctx, useTOTPStep, userID, step)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *Repository) UseRecoveryCode(ctx context.Context, userID, code string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, useRecoveryCode, userID, code)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *Repository) DeleteTOTP(ctx context.Context, userID string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, delTOTP, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, delRecoveryCodes, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, delUserChallenges, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *Repository) AddLoginChallenge(ctx context.Context, ch model.LoginChallenge) error {
+	_, err := r.pool.Exec(ctx, addChallenge, ch.ID, ch.UserID, ch.Expires)
+	return err
+}
+
+// UseLoginChallenge учитывает очередную попытку ввода второго фактора по запросу входа.
+func (r *Repository) UseLoginChallenge(ctx context.Context, id string) (model.LoginChallenge, error) {
+	var res model.LoginChallenge
+	row := r.pool.QueryRow(ctx, incChallenge, id)
+	err := row.Scan(&res.ID, &res.UserID, &res.Expires, &res.Attempts)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return res, config.ErrNoSuchRecord
+		}
+		return res, err
+	}
+	return res, nil
+}
+
+func (r *Repository) DeleteLoginChallenge(ctx context.Context, id string) error {
+	_, err := r.pool.Exec(ctx, delChallenge, id)
+	return err
+}
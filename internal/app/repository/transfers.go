@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// строки балансов блокируются в порядке user_id, чтобы встречные переводы не взаимоблокировались
+	lockTransferBalances = "SELECT user_id FROM user_balances WHERE user_id IN ($1, $2) ORDER BY user_id FOR UPDATE;"
+	getTransferred       = "SELECT COALESCE(SUM(amount), 0) FROM point_transfers WHERE sender_id = $1 AND regdate > $2;"
+	addTransfer          = "INSERT INTO point_transfers (sender_id, recipient_id, amount, regdate) VALUES ($1, $2, $3, $4) RETURNING id;"
+	getUserTransfers     = `SELECT t.id, t.sender_id, t.recipient_id, u.name, t.recipient_id = $1, t.amount, t.regdate
+		FROM point_transfers t JOIN users u ON u.id = CASE WHEN t.sender_id = $1 THEN t.recipient_id ELSE t.sender_id END
+		WHERE t.sender_id = $1 OR t.recipient_id = $1 ORDER BY t.regdate;`
+)
+
+// AddTransfer переводит баллы в одной транзакции: записывает перевод и проводки по счетам
+// отправителя и получателя. Если с учетом перевода сумма переводов отправителя после since
+// превысит limit (limit > 0), возвращается ErrTransferLimit; если баллов не хватает - ErrNotEnoughAccruals.
+// Получатель получает баллы с датами начисления отправителя, срок сгорания не сдвигается.
+func (r *Repository) AddTransfer(ctx context.Context, t *model.Transfer, since time.Time, limit int) error {
+	return r.inTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, lockTransferBalances, t.SenderID, t.RecipientID)
+		if err != nil {
+			return err
+		}
+		if limit > 0 {
+			var sent int
+			err = tx.QueryRow(ctx, getTransferred, t.SenderID, since).Scan(&sent)
+			if err != nil {
+				return err
+			}
+			if sent+t.Amount > limit {
+				return config.ErrTransferLimit
+			}
+		}
+		err = tx.QueryRow(ctx, addTransfer, t.SenderID, t.RecipientID, t.Amount, t.GenTime).Scan(&t.ID)
+		if err != nil {
+			return err
+		}
+		ref := strconv.FormatInt(t.ID, 10)
+		err = postEntry(ctx, tx, &model.LedgerEntry{
+			UserID:  t.SenderID,
+			Kind:    model.LedgerTransferOut,
+			Debit:   model.AccountUser,
+			Credit:  model.AccountTransfers,
+			Amount:  t.Amount,
+			Ref:     ref,
+			GenTime: t.GenTime,
+		})
+		if err != nil {
+			return err
+		}
+		err = postEntry(ctx, tx, &model.LedgerEntry{
+			UserID:  t.RecipientID,
+			Kind:    model.LedgerTransferIn,
+			Debit:   model.AccountTransfers,
+			Credit:  model.AccountUser,
+			Amount:  t.Amount,
+			Ref:     ref,
+			GenTime: t.GenTime,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, moveLots, t.SenderID, t.RecipientID, t.Amount)
+		return err
+	})
+}
+
+// GetUserTransfers возвращает отправленные и полученные пользователем переводы.
+func (r *Repository) GetUserTransfers(ctx context.Context, userID string) ([]model.Transfer, error) {
+	res := make([]model.Transfer, 0)
+	rows, err := r.pool.Query(ctx, getUserTransfers, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rec := model.Transfer{}
+		err := rows.Scan(&rec.ID, &rec.SenderID, &rec.RecipientID, &rec.Counterparty, &rec.Incoming, &rec.Amount, &rec.GenTime)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rec)
+	}
+	return res, rows.Err()
+}
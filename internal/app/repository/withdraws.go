@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	lockWithdraw = `SELECT id, order_id, user_id, regdate, withdraw, status FROM withdraws
+		WHERE order_id = $1 AND NOT legacy_duplicate FOR UPDATE;`
+	// по id доступны и дубликаты номера заказа, записанные до введения уникальности
+	lockWithdrawByID = `SELECT id, order_id, user_id, regdate, withdraw, status FROM withdraws
+		WHERE id = $1 FOR UPDATE;`
+	reverseWithdraw = "UPDATE withdraws SET status = $2, reversed = $3 WHERE id = $1;"
+)
+
+// ReverseWithdraw переводит списание с номером заказа orderID в состояние status и возвращает
+// баллы проводкой сторно. check вызывается для заблокированной строки списания и может отменить операцию.
+func (r *Repository) ReverseWithdraw(ctx context.Context, orderID, status string, check func(w model.Withdraw) error) (model.Withdraw, error) {
+	return r.reverseWithdraw(ctx, lockWithdraw, orderID, status, check)
+}
+
+// ReverseWithdrawByID - ReverseWithdraw для списания по его id.
+func (r *Repository) ReverseWithdrawByID(ctx context.Context, id int64, status string, check func(w model.Withdraw) error) (model.Withdraw, error) {
+	return r.reverseWithdraw(ctx, lockWithdrawByID, id, status, check)
+}
+
+func (r *Repository) reverseWithdraw(ctx context.Context, lock string, key any, status string, check func(w model.Withdraw) error) (model.Withdraw, error) {
+	var res model.Withdraw
+	err := r.inTx(ctx, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, lock, key)
+		err := row.Scan(&res.ID, &res.OrderID, &res.UserID, &res.GenTime, &res.Withdraw, &res.Status)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return config.ErrNoSuchRecord
+			}
+			return err
+		}
+		err = check(res)
+		if err != nil {
+			return err
+		}
+		if res.Status != model.WithdrawDone {
+			return config.ErrWithdrawReversed
+		}
+		now := time.Now()
+		_, err = tx.Exec(ctx, reverseWithdraw, res.ID, status, now)
+		if err != nil {
+			return err
+		}
+		res.Status = status
+		return postEntry(ctx, tx, &model.LedgerEntry{
+			UserID:  res.UserID,
+			Kind:    model.LedgerReversal,
+			Debit:   model.AccountWithdrawals,
+			Credit:  model.AccountUser,
+			Amount:  res.Withdraw,
+			Ref:     res.OrderID,
+			GenTime: now,
+		})
+	})
+	return res, err
+}
@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// JobFunc выполняет одну итерацию обслуживания и возвращает количество обработанных записей.
+type JobFunc = func(ctx context.Context) (int64, error)
+
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      JobFunc
+}
+
+// Scheduler запускает периодические задания обслуживания. Каждое задание выполняется
+// сразу при старте и далее с заданным интервалом, пока не отменен контекст.
+type Scheduler struct {
+	jobs []Job
+	wg   sync.WaitGroup
+}
+
+func New() *Scheduler {
+	return &Scheduler{}
+}
+
+// Add добавляет задание, задания с нулевым интервалом отключены.
+func (s *Scheduler) Add(name string, interval time.Duration, run JobFunc) {
+	if interval <= 0 {
+		log.Printf("scheduler: job %s disabled", name)
+		return
+	}
+	s.jobs = append(s.jobs, Job{Name: name, Interval: interval, Run: run})
+}
+
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, job)
+	}
+}
+
+// Wait ожидает завершения заданий после отмены контекста.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		s.runJob(ctx, job)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Printf("scheduler: job %s stop", job.Name)
+			return
+		}
+	}
+}
+
+func (s *Scheduler) runJob(ctx context.Context, job Job) {
+	start := time.Now()
+	n, err := job.Run(ctx)
+	if err != nil {
+		log.Printf("scheduler: job %s failed after %s: %v", job.Name, time.Since(start), err)
+		return
+	}
+	log.Printf("scheduler: job %s done in %s, records: %d", job.Name, time.Since(start), n)
+}
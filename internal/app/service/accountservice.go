@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+	"yp-diploma/internal/app/util"
+)
+
+// ExportAccount собирает все данные текущего пользователя для выгрузки.
+func (s *Service) ExportAccount(ctx context.Context) (model.AccountExport, error) {
+	userID := getUserIDFromCtx(ctx)
+	res := model.AccountExport{GenTime: time.Now()}
+	var err error
+
+	res.User, err = s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return res, err
+	}
+	res.Profile, err = s.userProfile(ctx, userID)
+	if err != nil {
+		return res, err
+	}
+	totp, err := s.repo.GetTOTP(ctx, userID)
+	switch err {
+	case nil:
+		res.TOTPEnabled = totp.Confirmed
+	case config.ErrNoSuchRecord:
+	default:
+		return res, err
+	}
+	res.Balance, err = s.userBalance(ctx, userID)
+	if err != nil {
+		return res, err
+	}
+	res.Orders, err = s.userOrders(ctx, userID)
+	if err != nil {
+		return res, err
+	}
+	res.Withdraws, err = s.repo.GetWithdrawList(ctx, userID)
+	if err != nil {
+		return res, err
+	}
+	res.Transfers, err = s.repo.GetUserTransfers(ctx, userID)
+	if err != nil {
+		return res, err
+	}
+	res.Adjustments, err = s.repo.GetUserAdjustments(ctx, userID)
+	if err != nil {
+		return res, err
+	}
+	res.Sessions, err = s.repo.GetUserSessions(ctx, userID)
+	if err != nil {
+		return res, err
+	}
+	res.APIKeys, err = s.repo.GetUserAPIKeys(ctx, userID)
+	return res, err
+}
+
+// CloseAccount закрывает учетную запись текущего пользователя после проверки пароля
+// (и кода, если включен второй фактор). Пользователю без пароля, вошедшему через OIDC,
+// достаточно действующей сессии и второго фактора. Неверные пароль и код учитываются как неудачные
+// попытки входа. Имя заменяется случайным, освобождая его для регистрации.
+func (s *Service) CloseAccount(ctx context.Context, password, code, ip string) error {
+	userID := getUserIDFromCtx(ctx)
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = s.confirmPasswd(ctx, user, password, ip)
+	if err != nil {
+		return err
+	}
+	totp, err := s.repo.GetTOTP(ctx, userID)
+	switch {
+	case err == nil && totp.Confirmed:
+		err = s.confirmSecondFactor(ctx, user, code, ip)
+		if err != nil {
+			return err
+		}
+	case err != nil && err != config.ErrNoSuchRecord:
+		return err
+	}
+
+	suffix, err := util.GetRandHexString(8)
+	if err != nil {
+		return err
+	}
+	err = s.repo.CloseAccount(ctx, userID, "del_"+suffix, time.Now())
+	s.sessCache.Invalidate(model.RevokeUser + ":" + userID)
+	return err
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+
+	"github.com/google/uuid"
+)
+
+const adminSearchLimit = 50
+
+// действия администратора, фиксируемые в журнале admin_audit
+const (
+	auditSearchUsers    = "search_users"
+	auditViewOrders     = "view_orders"
+	auditViewWithdraws  = "view_withdrawals"
+	auditViewBalance    = "view_balance"
+	auditLockUser       = "lock_user"
+	auditUnlockUser     = "unlock_user"
+	auditAdjustBalance  = "adjust_balance"
+	auditViewDispatcher = "view_dispatcher"
+	auditRefundWithdraw = "refund_withdrawal"
+)
+
+// PromoteAdmins назначает роль администратора пользователям из ADMIN_USERS.
+// Учетные записи должны быть зарегистрированы заранее.
+func (s *Service) PromoteAdmins(ctx context.Context) error {
+	if len(s.conf.AdminUsers) == 0 {
+		return nil
+	}
+	names, err := s.repo.SetAdmins(ctx, s.conf.AdminUsers)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		log.Printf("user %s granted admin role", name)
+	}
+	return nil
+}
+
+func (s *Service) VerifyAdmin(ctx context.Context, userID string) error {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Role != model.RoleAdmin || user.Locked {
+		return config.ErrNotAdmin
+	}
+	return nil
+}
+
+func (s *Service) SearchUsers(ctx context.Context, query string) ([]model.User, error) {
+	err := s.audit(ctx, auditSearchUsers, "", fmt.Sprintf("query=%q", query))
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.SearchUsers(ctx, query, adminSearchLimit)
+}
+
+func (s *Service) AdminUserOrders(ctx context.Context, userID string) ([]model.Order, error) {
+	_, err := s.adminTarget(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.audit(ctx, auditViewOrders, userID, "")
+	if err != nil {
+		return nil, err
+	}
+	return s.userOrders(ctx, userID)
+}
+
+func (s *Service) AdminUserWithdraws(ctx context.Context, userID string) ([]model.Withdraw, error) {
+	_, err := s.adminTarget(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.audit(ctx, auditViewWithdraws, userID, "")
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetWithdrawList(ctx, userID)
+}
+
+func (s *Service) AdminUserBalance(ctx context.Context, userID string) (model.Balance, error) {
+	_, err := s.adminTarget(ctx, userID)
+	if err != nil {
+		return model.Balance{}, err
+	}
+	err = s.audit(ctx, auditViewBalance, userID, "")
+	if err != nil {
+		return model.Balance{}, err
+	}
+	return s.userBalance(ctx, userID)
+}
+
+// LockUser блокирует учетную запись: вход запрещается, все сессии отзываются.
+func (s *Service) LockUser(ctx context.Context, userID, reason string) error {
+	_, err := s.adminTarget(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = s.repo.SetUserLocked(ctx, userID, true)
+	if err != nil {
+		return err
+	}
+	err = s.repo.RevokeUserSessions(ctx, userID, "")
+	s.sessCache.Invalidate(model.RevokeUser + ":" + userID)
+	if err != nil {
+		return err
+	}
+	return s.audit(ctx, auditLockUser, userID, reason)
+}
+
+// UnlockUser снимает как блокировку администратора, так и блокировку за подбор пароля.
+func (s *Service) UnlockUser(ctx context.Context, userID, reason string) error {
+	user, err := s.adminTarget(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = s.repo.SetUserLocked(ctx, userID, false)
+	if err != nil {
+		return err
+	}
+	err = s.repo.ResetLoginAttempts(ctx, attemptKeyUser(user.Name))
+	if err != nil {
+		return err
+	}
+	return s.audit(ctx, auditUnlockUser, userID, reason)
+}
+
+// AdjustBalance начисляет (amount > 0) или списывает (amount < 0) баллы вручную.
+// Причина обязательна, списание не может увести баланс в минус.
+func (s *Service) AdjustBalance(ctx context.Context, userID string, amount int, reason string) error {
+	if amount == 0 || reason == "" {
+		return config.ErrInvalidData
+	}
+	_, err := s.adminTarget(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	adj := model.Adjustment{
+		UserID:  userID,
+		AdminID: getUserIDFromCtx(ctx),
+		Amount:  amount,
+		Reason:  reason,
+		GenTime: time.Now(),
+	}
+	err = s.repo.AddAdjustment(ctx, adj)
+	if err != nil {
+		return err
+	}
+	return s.audit(ctx, auditAdjustBalance, userID, fmt.Sprintf("amount=%d reason=%q", amount, reason))
+}
+
+// RefundWithdraw возвращает баллы по любому списанию без ограничения по времени. Списание
+// задается id, поэтому доступны и помеченные legacy_duplicate дубликаты номера заказа.
+func (s *Service) RefundWithdraw(ctx context.Context, withdrawID, reason string) error {
+	id, err := strconv.ParseInt(withdrawID, 10, 64)
+	if err != nil {
+		return config.ErrNoSuchRecord
+	}
+	w, err := s.repo.ReverseWithdrawByID(ctx, id, model.WithdrawRefunded, func(model.Withdraw) error {
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.audit(ctx, auditRefundWithdraw, w.UserID, fmt.Sprintf("id=%d order=%s sum=%d reason=%q", w.ID, w.OrderID, w.Withdraw, reason))
+}
+
+func (s *Service) DispatcherState(ctx context.Context) (model.DispatcherState, error) {
+	err := s.audit(ctx, auditViewDispatcher, "", "")
+	if err != nil {
+		return model.DispatcherState{}, err
+	}
+	return s.orderDisp.State(), nil
+}
+
+// adminTarget проверяет, что пользователь, над которым выполняется действие, существует.
+func (s *Service) adminTarget(ctx context.Context, userID string) (model.User, error) {
+	_, err := uuid.Parse(userID)
+	if err != nil {
+		return model.User{}, config.ErrNoSuchRecord
+	}
+	return s.repo.GetUserByID(ctx, userID)
+}
+
+// audit записывает действие администратора в журнал. Данные для просмотра выдаются
+// только после успешной записи, изменения записываются в журнал после выполнения.
+func (s *Service) audit(ctx context.Context, action, targetID, details string) error {
+	rec := model.AuditRecord{
+		AdminID:  getUserIDFromCtx(ctx),
+		Action:   action,
+		TargetID: targetID,
+		Details:  details,
+		GenTime:  time.Now(),
+	}
+	err := s.repo.AddAuditRecord(ctx, rec)
+	if err != nil {
+		log.Printf("error writing audit record %s by %s: %v", action, rec.AdminID, err)
+	}
+	return err
+}
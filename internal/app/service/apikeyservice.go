@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"strings"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+	"yp-diploma/internal/app/util"
+)
+
+// ключ выдается в виде gmk_<id>_<secret>, id нужен для поиска записи, в БД хранится только хеш secret
+const apiKeyPrefix = "gmk_"
+
+// CreateAPIKey создает ключ с указанными областями доступа. Сам ключ возвращается
+// только здесь, в дальнейшем доступны лишь его свойства.
+func (s *Service) CreateAPIKey(ctx context.Context, name string, scopes []string, expires time.Time) (model.APIKey, string, error) {
+	userID := getUserIDFromCtx(ctx)
+	if len(scopes) == 0 {
+		return model.APIKey{}, "", config.ErrInvalidScope
+	}
+	for _, scope := range scopes {
+		if !hasScope(model.APIKeyScopes, scope) {
+			return model.APIKey{}, "", config.ErrInvalidScope
+		}
+	}
+	if name == "" || len(name) > 64 || (!expires.IsZero() && expires.Before(time.Now())) {
+		return model.APIKey{}, "", config.ErrInvalidData
+	}
+
+	id, err := util.GetRandHexString(8)
+	if err != nil {
+		return model.APIKey{}, "", err
+	}
+	secret, err := util.GetRandHexString(32)
+	if err != nil {
+		return model.APIKey{}, "", err
+	}
+	key := model.APIKey{
+		ID:      id,
+		UserID:  userID,
+		Name:    name,
+		Secret:  hashToken(secret),
+		Scopes:  scopes,
+		Created: time.Now(),
+		Expires: expires,
+	}
+	err = s.repo.AddAPIKey(ctx, key)
+	if err != nil {
+		return model.APIKey{}, "", err
+	}
+	return key, apiKeyPrefix + id + "_" + secret, nil
+}
+
+func (s *Service) GetAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	return s.repo.GetUserAPIKeys(ctx, getUserIDFromCtx(ctx))
+}
+
+func (s *Service) DeleteAPIKey(ctx context.Context, id string) error {
+	return s.repo.DeleteAPIKey(ctx, getUserIDFromCtx(ctx), id)
+}
+
+// VerifyAPIKey проверяет ключ и возвращает владельца и области доступа ключа.
+func (s *Service) VerifyAPIKey(ctx context.Context, apiKey string) (string, []string, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(apiKey, apiKeyPrefix), "_")
+	if !ok || !strings.HasPrefix(apiKey, apiKeyPrefix) {
+		return "", nil, config.ErrInvalidAPIKey
+	}
+	key, err := s.repo.GetAPIKey(ctx, id)
+	if err != nil {
+		if err == config.ErrNoSuchRecord {
+			return "", nil, config.ErrInvalidAPIKey
+		}
+		return "", nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Secret), []byte(hashToken(secret))) != 1 {
+		return "", nil, config.ErrInvalidAPIKey
+	}
+	if !key.Expires.IsZero() && time.Now().After(key.Expires) {
+		return "", nil, config.ErrTokenExpired
+	}
+	// сессии заблокированного пользователя отзываются сразу, ключи проверяются здесь
+	user, err := s.repo.GetUserByID(ctx, key.UserID)
+	if err != nil {
+		return "", nil, err
+	}
+	if user.Locked {
+		return "", nil, config.ErrUserLocked
+	}
+	err = s.repo.TouchAPIKey(ctx, key.ID)
+	if err != nil {
+		log.Printf("error updating api key %s usage: %v", key.ID, err)
+	}
+	return key.UserID, key.Scopes, nil
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+	"yp-diploma/internal/app/util"
+
+	"github.com/google/uuid"
+)
+
+// количество просроченных резервов, освобождаемых за один запрос к БД
+const holdReleaseBatch = 100
+
+// ReserveHold резервирует баллы текущего пользователя под заказ на HoldTTL.
+// Зарезервированные баллы не входят в доступный баланс до подтверждения или отмены.
+func (s *Service) ReserveHold(ctx context.Context, orderID string, amount int) (model.Hold, error) {
+	if !util.LuhnCheck(orderID) {
+		return model.Hold{}, config.ErrLuhnCheckFailed
+	}
+	now := time.Now()
+	h := model.Hold{
+		ID:      uuid.New().String(),
+		UserID:  getUserIDFromCtx(ctx),
+		OrderID: orderID,
+		Amount:  amount,
+		Status:  model.HoldActive,
+		GenTime: now,
+		Expires: now.Add(s.conf.HoldTTL),
+	}
+	err := s.repo.AddHold(ctx, h)
+	if err != nil {
+		return model.Hold{}, err
+	}
+	return h, nil
+}
+
+// CaptureHold подтверждает резерв: по его номеру заказа записывается списание.
+func (s *Service) CaptureHold(ctx context.Context, holdID string) (model.Hold, error) {
+	return s.settleUserHold(ctx, holdID, model.HoldCaptured)
+}
+
+// ReleaseHold отменяет резерв и возвращает баллы на баланс.
+func (s *Service) ReleaseHold(ctx context.Context, holdID string) (model.Hold, error) {
+	return s.settleUserHold(ctx, holdID, model.HoldReleased)
+}
+
+func (s *Service) settleUserHold(ctx context.Context, holdID, status string) (model.Hold, error) {
+	if _, err := uuid.Parse(holdID); err != nil {
+		return model.Hold{}, config.ErrNoSuchRecord
+	}
+	userID := getUserIDFromCtx(ctx)
+	return s.repo.SettleHold(ctx, holdID, status, func(h model.Hold) error {
+		if h.UserID != userID {
+			return config.ErrNoSuchRecord
+		}
+		// просроченный резерв освобождается заданием, подтвердить его уже нельзя
+		if status == model.HoldCaptured && h.Status == model.HoldActive && time.Now().After(h.Expires) {
+			return config.ErrHoldExpired
+		}
+		return nil
+	})
+}
+
+func (s *Service) GetHolds(ctx context.Context) ([]model.Hold, error) {
+	return s.repo.GetUserHolds(ctx, getUserIDFromCtx(ctx))
+}
+
+// releaseExpiredHolds возвращает баллы по резервам с истекшим сроком.
+func (s *Service) releaseExpiredHolds(ctx context.Context) (int64, error) {
+	var total int64
+	now := time.Now()
+	for {
+		ids, err := s.repo.GetExpiredHolds(ctx, now, holdReleaseBatch)
+		if err != nil {
+			return total, err
+		}
+		for _, id := range ids {
+			_, err = s.repo.SettleHold(ctx, id, model.HoldExpired, func(model.Hold) error {
+				return nil
+			})
+			switch err {
+			case nil:
+				total++
+			case config.ErrHoldSettled:
+				// резерв подтвержден или отменен после выборки
+			default:
+				return total, err
+			}
+		}
+		if len(ids) < holdReleaseBatch {
+			return total, nil
+		}
+	}
+}
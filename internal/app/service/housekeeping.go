@@ -0,0 +1,27 @@
+package service
+
+import (
+	"context"
+	"time"
+	"yp-diploma/internal/app/scheduler"
+)
+
+// RegisterHousekeeping добавляет в планировщик задания обслуживания БД.
+func (s *Service) RegisterHousekeeping(sch *scheduler.Scheduler) {
+	sch.Add("purge expired sessions", s.conf.SessionPurgeInterval, s.repo.PurgeExpiredSessions)
+	sch.Add("purge login attempts", s.conf.AttemptsPurgeInterval, s.purgeLoginAttempts)
+	sch.Add("vacuum old events", s.conf.EventsVacuumInterval, s.vacuumEvents)
+	sch.Add("purge idempotency keys", s.conf.AttemptsPurgeInterval, s.purgeIdempotencyKeys)
+	sch.Add("release expired holds", s.conf.HoldReleaseInterval, s.releaseExpiredHolds)
+	if s.conf.PointsTTL > 0 {
+		sch.Add("expire points", s.conf.PointsExpiryInterval, s.expirePoints)
+	}
+}
+
+func (s *Service) purgeLoginAttempts(ctx context.Context) (int64, error) {
+	return s.repo.PurgeLoginAttempts(ctx, time.Now().Add(-s.conf.AttemptsRetention))
+}
+
+func (s *Service) vacuumEvents(ctx context.Context) (int64, error) {
+	return s.repo.VacuumEvents(ctx, time.Now().Add(-s.conf.EventsRetention))
+}
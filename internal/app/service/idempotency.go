@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+)
+
+// BeginIdempotent регистрирует запрос текущего пользователя с ключом идемпотентности.
+// Возвращает сохраненный ответ и true, если запрос с этим ключом уже выполнен и ответ надо повторить.
+func (s *Service) BeginIdempotent(ctx context.Context, key, requestHash string) (model.IdempotentResponse, bool, error) {
+	// время занятия ключа входит в идентификатор записи, точность совпадает с timestamptz
+	now := time.Now().Truncate(time.Microsecond)
+	rec := model.IdempotentResponse{
+		UserID:      getUserIDFromCtx(ctx),
+		Key:         key,
+		RequestHash: requestHash,
+		Created:     now,
+	}
+	stored, claimed, err := s.repo.ClaimIdempotencyKey(ctx, rec, now.Add(-s.conf.IdempotencyTTL), now.Add(-s.conf.IdempotencyLease))
+	switch {
+	case err != nil:
+		return rec, false, err
+	case claimed:
+		return rec, false, nil
+	case stored.RequestHash != requestHash:
+		return rec, false, config.ErrIdempotencyMismatch
+	case stored.Status == 0:
+		return rec, false, config.ErrIdempotencyInProgress
+	default:
+		return stored, true, nil
+	}
+}
+
+// FinishIdempotent сохраняет ответ на запрос, занявший ключ.
+func (s *Service) FinishIdempotent(ctx context.Context, resp model.IdempotentResponse) error {
+	return s.repo.SaveIdempotentResponse(ctx, resp)
+}
+
+// AbortIdempotent освобождает ключ, если запрос не удалось выполнить, чтобы его можно было повторить.
+func (s *Service) AbortIdempotent(ctx context.Context, resp model.IdempotentResponse) error {
+	return s.repo.DeleteIdempotencyKey(ctx, resp)
+}
+
+func (s *Service) purgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	return s.repo.PurgeIdempotencyKeys(ctx, time.Now().Add(-s.conf.IdempotencyTTL))
+}
@@ -22,6 +22,11 @@ type jobDispatcher struct {
 	jobsPool  *jobPool
 	processor *Processor
 	resFunc   resultFunc
+	// статистика последней итерации, для администратора
+	mu         sync.RWMutex
+	lastRun    time.Time
+	lastBatch  int
+	lastErrors int
 }
 
 func NewDispatcher(ctx context.Context, jpool *jobPool, jFunc jobFunc, rFunc resultFunc) *jobDispatcher {
@@ -64,6 +69,7 @@ func (jd *jobDispatcher) Dispatch() {
 					}
 				}
 				jobs, errs := jd.processor.ProceedWith(jd.ctx, jobs)
+				jd.saveStats(len(jobs)+len(errs), len(errs))
 				if len(errs) > 0 {
 					// обрабатываем полученные ошибки,
 					// задания, на которых они возникли, остаются в пуле для повторной обработки
@@ -90,6 +96,26 @@ func (jd *jobDispatcher) Dispatch() {
 	}()
 }
 
+func (jd *jobDispatcher) saveStats(batch, errs int) {
+	jd.mu.Lock()
+	defer jd.mu.Unlock()
+	jd.lastRun = time.Now()
+	jd.lastBatch = batch
+	jd.lastErrors = errs
+}
+
+// State - снимок пула заданий и результаты последней итерации.
+func (jd *jobDispatcher) State() model.DispatcherState {
+	jd.mu.RLock()
+	defer jd.mu.RUnlock()
+	return model.DispatcherState{
+		Orders:     jd.jobsPool.CopyState(),
+		LastRun:    jd.lastRun,
+		LastBatch:  jd.lastBatch,
+		LastErrors: jd.lastErrors,
+	}
+}
+
 func NewJobPool() *jobPool {
 	jp := &jobPool{}
 	jp.pool = make(map[string]model.Order, 0)
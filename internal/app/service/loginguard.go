@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+)
+
+func attemptKeyUser(name string) string {
+	return "user:" + name
+}
+
+func attemptKeyIP(ip string) string {
+	return "ip:" + ip
+}
+
+// запросы сброса пароля считаются отдельно от входа, по адресу почты и клиенту
+func attemptKeyReset(email string) string {
+	return "reset:" + strings.ToLower(email)
+}
+
+func attemptKeyResetIP(ip string) string {
+	return "reset-ip:" + ip
+}
+
+// checkLoginAllowed возвращает ErrTooManyAttempts, если хотя бы один из ключей
+// (учетная запись, адрес клиента) еще заблокирован.
+func (s *Service) checkLoginAllowed(ctx context.Context, keys ...string) error {
+	now := time.Now()
+	for _, key := range keys {
+		attempt, err := s.repo.GetLoginAttempt(ctx, key)
+		switch err {
+		case nil:
+			if now.Before(attempt.LockedUntil) {
+				return config.ErrTooManyAttempts
+			}
+		case config.ErrNoSuchRecord:
+			continue
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// registerLoginFailure учитывает неудачную попытку входа для учетной записи и адреса клиента.
+// Учетная запись получает нарастающую задержку, адрес - только блокировку по превышению лимита,
+// чтобы не мешать пользователям за общим NAT.
+func (s *Service) registerLoginFailure(ctx context.Context, name, ip string) {
+	s.addLoginFailure(ctx, attemptKeyUser(name), s.conf.LoginFreeAttempts, s.conf.LoginMaxFailures)
+	s.addLoginFailure(ctx, attemptKeyIP(ip), s.conf.LoginMaxFailuresIP, s.conf.LoginMaxFailuresIP)
+}
+
+func (s *Service) addLoginFailure(ctx context.Context, key string, free, maxFailures int) {
+	attempt, err := s.repo.AddLoginFailure(ctx, key, time.Now().Add(-s.conf.LoginFailureWindow))
+	if err != nil {
+		log.Printf("error saving login failure for %s: %v", key, err)
+		return
+	}
+	delay := s.loginDelay(attempt.Failures, free, maxFailures)
+	if delay == 0 {
+		return
+	}
+	if attempt.Failures >= maxFailures {
+		log.Printf("login locked for %s after %d failures", key, attempt.Failures)
+	}
+	err = s.repo.SetLoginLock(ctx, key, attempt.LastFailure.Add(delay))
+	if err != nil {
+		log.Printf("error saving login lock for %s: %v", key, err)
+	}
+}
+
+func (s *Service) loginDelay(failures, free, maxFailures int) time.Duration {
+	if failures >= maxFailures {
+		return s.conf.LoginLockout
+	}
+	if failures < free {
+		return 0
+	}
+	// сдвиг сравнивается с блокировкой до выполнения, чтобы задержка не переполнилась
+	n := failures - free
+	if n >= 62 || s.conf.LoginDelay > s.conf.LoginLockout>>n {
+		return s.conf.LoginLockout
+	}
+	return s.conf.LoginDelay << n
+}
+
+// confirmPasswd повторно проверяет пароль пользователя (если он задан) перед опасным действием.
+// Неверный пароль учитывается как неудачная попытка входа: украденная сессия не должна
+// позволять подбирать пароль в обход ограничений входа.
+func (s *Service) confirmPasswd(ctx context.Context, user model.User, password, ip string) error {
+	err := s.checkLoginAllowed(ctx, attemptKeyUser(user.Name), attemptKeyIP(ip))
+	if err != nil {
+		return err
+	}
+	if hasPasswd(user) && !CheckPasswd(password, user.HashedPasswd) {
+		s.registerLoginFailure(ctx, user.Name, ip)
+		return config.ErrUserInvalidPassword
+	}
+	return nil
+}
+
+// confirmSecondFactor проверяет код второго фактора, неверный код учитывается как неудачная попытка входа.
+func (s *Service) confirmSecondFactor(ctx context.Context, user model.User, code, ip string) error {
+	err := s.checkSecondFactor(ctx, user.ID, code)
+	if err == config.ErrInvalidOTP {
+		s.registerLoginFailure(ctx, user.Name, ip)
+	}
+	return err
+}
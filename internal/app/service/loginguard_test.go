@@ -0,0 +1,35 @@
+package service
+
+import (
+	"testing"
+	"time"
+	"yp-diploma/internal/app/config"
+)
+
+func TestLoginDelay(t *testing.T) {
+	s := &Service{conf: &config.Config{LoginDelay: time.Second, LoginLockout: 15 * time.Minute}}
+	tests := []struct {
+		name                     string
+		failures, free, maxFails int
+		want                     time.Duration
+	}{
+		{"no failures", 0, 3, 10, 0},
+		{"free attempts", 2, 3, 10, 0},
+		{"first delayed", 3, 3, 10, time.Second},
+		{"doubling", 5, 3, 10, 4 * time.Second},
+		{"capped by lockout", 10, 0, 20, 15 * time.Minute},
+		{"locked", 10, 3, 10, 15 * time.Minute},
+		{"ip lock only", 4, 5, 5, 0},
+		{"ip locked", 5, 5, 5, 15 * time.Minute},
+		{"shift overflow", 43, 3, 100, 15 * time.Minute},
+		{"huge shift", 90, 3, 100, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.loginDelay(tt.failures, tt.free, tt.maxFails)
+			if got != tt.want {
+				t.Errorf("loginDelay(%d, %d, %d) = %v, want %v", tt.failures, tt.free, tt.maxFails, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+	"yp-diploma/internal/app/oidc"
+	"yp-diploma/internal/app/util"
+
+	"github.com/google/uuid"
+)
+
+// длина имени пользователя ограничена столбцом users.name
+const (
+	maxUserName    = 20
+	oidcNameTries  = 5
+	oidcNameSuffix = 2
+)
+
+// StartOIDCLogin начинает вход через провайдера OpenID Connect. Возвращает адрес страницы
+// входа провайдера и зашифрованное состояние входа, которое сохраняется в cookie браузера.
+func (s *Service) StartOIDCLogin(ctx context.Context) (string, string, error) {
+	st := model.OIDCState{Expires: time.Now().Add(config.OIDCLoginDuration)}
+	var err error
+	for _, v := range []*string{&st.State, &st.Nonce, &st.Verifier} {
+		*v, err = oidc.NewVerifier()
+		if err != nil {
+			return "", "", err
+		}
+	}
+	authURL, err := s.oidc.AuthCodeURL(ctx, st.State, st.Nonce, st.Verifier)
+	if err != nil {
+		log.Printf("error starting oidc login: %v", err)
+		return "", "", config.ErrOIDCLogin
+	}
+	cookie, err := util.EncodeOIDCState(st)
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, cookie, nil
+}
+
+// FinishOIDCLogin завершает вход по коду авторизации от провайдера. При первом входе
+// внешняя учетная запись связывается с пользователем по подтвержденному email
+// или для нее создается новый пользователь.
+func (s *Service) FinishOIDCLogin(ctx context.Context, stateCookie, state, code string) (model.AuthTokens, error) {
+	st, err := util.DecodeOIDCState(stateCookie)
+	if err != nil {
+		return model.AuthTokens{}, config.ErrInvalidToken
+	}
+	if subtle.ConstantTimeCompare([]byte(st.State), []byte(state)) != 1 {
+		return model.AuthTokens{}, config.ErrInvalidToken
+	}
+	claims, err := s.oidc.Exchange(ctx, code, st.Verifier, st.Nonce)
+	if err != nil {
+		log.Printf("error finishing oidc login: %v", err)
+		return model.AuthTokens{}, config.ErrOIDCLogin
+	}
+
+	user, err := s.oidcUser(ctx, claims)
+	if err != nil {
+		return model.AuthTokens{}, err
+	}
+	if user.Locked {
+		return model.AuthTokens{}, config.ErrUserLocked
+	}
+	mfa, err := s.startSecondFactor(ctx, user.ID)
+	if err != nil {
+		return mfa, err
+	}
+	return s.genSessKey(ctx, user.ID, newFamilyID())
+}
+
+func (s *Service) oidcUser(ctx context.Context, claims oidc.Claims) (model.User, error) {
+	issuer := s.oidc.Issuer()
+	user, err := s.repo.GetIdentityUser(ctx, issuer, claims.Subject)
+	if err != config.ErrNoSuchRecord {
+		return user, err
+	}
+	identity := model.Identity{
+		Issuer:  issuer,
+		Subject: claims.Subject,
+		Created: time.Now(),
+	}
+
+	if claims.Email != "" && claims.EmailVerified {
+		user, err = s.repo.GetUserByEmail(ctx, claims.Email)
+		switch err {
+		case nil:
+			identity.UserID = user.ID
+			err = s.repo.AddIdentity(ctx, identity)
+			if err == nil {
+				log.Printf("oidc identity %s linked to user %s by email", claims.Subject, user.Name)
+			}
+			return user, err
+		case config.ErrNoSuchRecord:
+		default:
+			return user, err
+		}
+	}
+
+	// у нового пользователя нет пароля, задать его можно через сброс пароля
+	user = model.User{
+		ID:   uuid.New().String(),
+		Role: model.RoleUser,
+	}
+	base := oidcUserName(claims)
+	for i := 0; i < oidcNameTries; i++ {
+		user.Name = base
+		if i > 0 {
+			suffix, err := util.GetRandHexString(oidcNameSuffix)
+			if err != nil {
+				return model.User{}, err
+			}
+			user.Name = truncate(base, maxUserName-len(suffix)-1) + "_" + suffix
+		}
+		err = s.repo.AddUserWithIdentity(ctx, user, identity)
+		if err != config.ErrUserNameBusy {
+			break
+		}
+	}
+	if err != nil {
+		return model.User{}, err
+	}
+	log.Printf("user %s created from oidc identity %s", user.Name, claims.Subject)
+
+	if claims.Email != "" && claims.EmailVerified {
+		err = s.repo.SaveProfile(ctx, model.Profile{
+			UserID:      user.ID,
+			Email:       claims.Email,
+			DisplayName: truncate(claims.Name, maxDisplayName),
+			Updated:     time.Now(),
+		})
+		if err != nil {
+			log.Printf("error saving profile for user %s: %v", user.Name, err)
+		}
+	}
+	return user, nil
+}
+
+// oidcUserName подбирает имя пользователя из preferred_username или email провайдера.
+func oidcUserName(claims oidc.Claims) string {
+	name := claims.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._-", r) {
+			return r
+		}
+		return -1
+	}, name)
+	if name == "" {
+		name = "user"
+	}
+	return truncate(name, maxUserName)
+}
+
+// truncate обрезает строку до n символов
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
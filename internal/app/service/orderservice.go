@@ -44,7 +44,10 @@ func (s *Service) NewOrder(ctx context.Context, orderNum string) error {
 }
 
 func (s *Service) GetOrdersList(ctx context.Context) ([]model.Order, error) {
-	userID := getUserIDFromCtx(ctx)
+	return s.userOrders(ctx, getUserIDFromCtx(ctx))
+}
+
+func (s *Service) userOrders(ctx context.Context, userID string) ([]model.Order, error) {
 	orderList, err := s.repo.GetUserOrders(ctx, userID)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode/utf8"
+	"yp-diploma/internal/app/config"
+)
+
+// самые распространенные пароли запрещены всегда, дополнительный список задается PASSWORD_BLOCKLIST
+var commonPasswords = []string{
+	"123456", "12345678", "123456789", "1234567890", "password", "password1",
+	"qwerty", "qwerty123", "qwertyuiop", "11111111", "00000000", "iloveyou",
+	"abc12345", "1q2w3e4r", "1qaz2wsx", "letmein", "welcome", "admin123",
+	"gophermart", "йцукенгшщз",
+}
+
+type passwordPolicy struct {
+	minLen  int
+	blocked map[string]struct{}
+}
+
+func newPasswordPolicy(minLen int, blocklistFile string) (*passwordPolicy, error) {
+	p := &passwordPolicy{
+		minLen:  minLen,
+		blocked: make(map[string]struct{}, len(commonPasswords)),
+	}
+	for _, pwd := range commonPasswords {
+		p.blocked[pwd] = struct{}{}
+	}
+	if blocklistFile == "" {
+		return p, nil
+	}
+
+	f, err := os.Open(blocklistFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		pwd := strings.TrimSpace(scanner.Text())
+		if pwd != "" {
+			p.blocked[strings.ToLower(pwd)] = struct{}{}
+		}
+	}
+	return p, scanner.Err()
+}
+
+func (p *passwordPolicy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.minLen {
+		return config.ErrPasswordTooShort
+	}
+	if _, ok := p.blocked[strings.ToLower(password)]; ok {
+		return config.ErrPasswordBlocked
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+	"yp-diploma/internal/app/util"
+
+	appmail "yp-diploma/internal/app/mail"
+)
+
+const (
+	maxDisplayName = 64
+	maxEmail       = 254
+)
+
+// язык и регион в виде BCP 47: "ru", "en-US", "zh-Hant-TW"
+var localeRe = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+func (s *Service) GetProfile(ctx context.Context) (model.Profile, error) {
+	return s.userProfile(ctx, getUserIDFromCtx(ctx))
+}
+
+func (s *Service) userProfile(ctx context.Context, userID string) (model.Profile, error) {
+	p, err := s.repo.GetProfile(ctx, userID)
+	switch err {
+	case nil:
+		return p, nil
+	case config.ErrNoSuchRecord:
+		return model.Profile{UserID: userID}, nil
+	default:
+		return model.Profile{}, err
+	}
+}
+
+// UpdateProfile меняет поля профиля. Новый адрес становится основным только после перехода
+// по ссылке из письма, до этого хранится как ожидающий подтверждения. Пустой адрес удаляет email.
+// Письмо отправляется после сохранения, ошибка отправки только журналируется: профиль уже
+// изменен, а адрес остается в pending_email до подтверждения.
+func (s *Service) UpdateProfile(ctx context.Context, upd model.ProfileUpdate) (model.Profile, error) {
+	p, err := s.userProfile(ctx, getUserIDFromCtx(ctx))
+	if err != nil {
+		return p, err
+	}
+	if upd.DisplayName != nil {
+		name := strings.TrimSpace(*upd.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayName || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+			return p, config.ErrInvalidProfile
+		}
+		p.DisplayName = name
+	}
+	if upd.Locale != nil {
+		if *upd.Locale != "" && (len(*upd.Locale) > 16 || !localeRe.MatchString(*upd.Locale)) {
+			return p, config.ErrInvalidProfile
+		}
+		p.Locale = *upd.Locale
+	}
+
+	var verify bool
+	if upd.Email != nil {
+		email, err := normalizeEmail(*upd.Email)
+		if err != nil {
+			return p, err
+		}
+		switch {
+		case email == "":
+			p.Email, p.PendingEmail = "", ""
+		case strings.EqualFold(email, p.Email):
+			p.PendingEmail = ""
+		default:
+			p.PendingEmail = email
+			verify = true
+		}
+	}
+	p.Updated = time.Now()
+	err = s.repo.SaveProfile(ctx, p)
+	if err != nil {
+		return p, err
+	}
+	if verify {
+		s.sendEmailVerification(ctx, p)
+	}
+	return p, nil
+}
+
+// VerifyEmail подтверждает адрес по токену из ссылки в письме.
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+	tok, err := util.DecodeEmailToken(token)
+	if err != nil {
+		return err
+	}
+	return s.repo.VerifyEmail(ctx, tok.UserID, tok.Email)
+}
+
+func (s *Service) sendEmailVerification(ctx context.Context, p model.Profile) {
+	token, err := util.EncodeEmailToken(model.EmailToken{
+		UserID:  p.UserID,
+		Email:   p.PendingEmail,
+		Expires: time.Now().Add(s.conf.EmailVerifyTTL),
+	})
+	if err != nil {
+		log.Printf("error creating verification token for user %s: %v", p.UserID, err)
+		return
+	}
+	link := s.conf.PublicURL + "/api/user/profile/verify?token=" + url.QueryEscape(token)
+	msg := appmail.Message{
+		To:      p.PendingEmail,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello%s,\r\n\r\nto confirm this address for your Gophermart account open the link below:\r\n\r\n%s\r\n\r\n"+
+			"The link is valid for %s. If you did not request this, ignore this message.\r\n",
+			greetingName(p), link, s.conf.EmailVerifyTTL),
+	}
+	err = s.mail.Send(ctx, msg)
+	if err != nil {
+		log.Printf("error sending verification email to user %s: %v", p.UserID, err)
+	}
+}
+
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > maxEmail {
+		return "", config.ErrInvalidEmail
+	}
+	return email, nil
+}
+
+func greetingName(p model.Profile) string {
+	if p.DisplayName == "" {
+		return ""
+	}
+	return " " + p.DisplayName
+}
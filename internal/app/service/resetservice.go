@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+	"yp-diploma/internal/app/util"
+
+	appmail "yp-diploma/internal/app/mail"
+)
+
+// ForgotPassword отправляет токен сброса пароля на подтвержденный адрес. Результат не зависит
+// от того, есть ли такой адрес: токен создается и письмо отправляется в фоне.
+func (s *Service) ForgotPassword(ctx context.Context, email, ip string) error {
+	email = strings.TrimSpace(email)
+	emailKey, ipKey := attemptKeyReset(email), attemptKeyResetIP(ip)
+	err := s.checkLoginAllowed(ctx, emailKey, ipKey)
+	if err != nil {
+		return err
+	}
+	s.addLoginFailure(ctx, emailKey, s.conf.PasswordResetMax, s.conf.PasswordResetMax)
+	s.addLoginFailure(ctx, ipKey, s.conf.PasswordResetMaxIP, s.conf.PasswordResetMaxIP)
+
+	user, err := s.repo.GetUserByEmail(ctx, email)
+	switch {
+	case err == config.ErrNoSuchRecord, err == nil && user.Locked:
+		return nil
+	case err != nil:
+		return err
+	}
+	go s.sendPasswordReset(context.Background(), user, email)
+	return nil
+}
+
+func (s *Service) sendPasswordReset(ctx context.Context, user model.User, email string) {
+	token, err := util.GetRandHexString(32)
+	if err != nil {
+		log.Printf("error creating password reset token for user %s: %v", user.ID, err)
+		return
+	}
+	reset := model.PasswordReset{
+		ID:      hashToken(token),
+		UserID:  user.ID,
+		Expires: time.Now().Add(s.conf.PasswordResetTTL),
+	}
+	err = s.repo.AddPasswordReset(ctx, reset)
+	if err != nil {
+		log.Printf("error saving password reset token for user %s: %v", user.ID, err)
+		return
+	}
+	msg := appmail.Message{
+		To:      email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Hello,\r\n\r\na password reset was requested for Gophermart account %s.\r\n"+
+			"Send the token below to %s/api/user/password/reset together with a new password:\r\n\r\n%s\r\n\r\n"+
+			"The token is valid for %s and can be used once. If you did not request this, ignore this message.\r\n",
+			user.Name, s.conf.PublicURL, token, s.conf.PasswordResetTTL),
+	}
+	err = s.mail.Send(ctx, msg)
+	if err != nil {
+		log.Printf("error sending password reset email to user %s: %v", user.ID, err)
+	}
+}
+
+// ResetPassword устанавливает новый пароль по токену из письма и отзывает все сессии пользователя.
+// Неверные токены учитываются как неудачные попытки клиента.
+func (s *Service) ResetPassword(ctx context.Context, token, password, ip string) error {
+	ipKey := attemptKeyResetIP(ip)
+	err := s.checkLoginAllowed(ctx, ipKey)
+	if err != nil {
+		return err
+	}
+	// пароль проверяется до погашения токена, чтобы слабый пароль не тратил токен
+	err = s.pwdPolicy.Check(password)
+	if err != nil {
+		return err
+	}
+	reset, err := s.repo.UsePasswordReset(ctx, hashToken(token))
+	if err != nil {
+		if err == config.ErrInvalidToken {
+			s.addLoginFailure(ctx, ipKey, s.conf.PasswordResetMaxIP, s.conf.PasswordResetMaxIP)
+		}
+		return err
+	}
+	user, err := s.repo.GetUserByID(ctx, reset.UserID)
+	if err != nil {
+		return err
+	}
+	err = s.repo.UpdatePasswd(ctx, user.ID, hashPasswd(password))
+	if err != nil {
+		return err
+	}
+	err = s.repo.DeletePasswordResets(ctx, user.ID)
+	if err != nil {
+		log.Printf("error deleting password reset tokens for user %s: %v", user.ID, err)
+	}
+	err = s.repo.ResetLoginAttempts(ctx, attemptKeyUser(user.Name))
+	if err != nil {
+		log.Printf("error resetting login attempts for %s: %v", user.Name, err)
+	}
+	err = s.repo.RevokeUserSessions(ctx, user.ID, "")
+	s.sessCache.Invalidate(model.RevokeUser + ":" + user.ID)
+	return err
+}
@@ -2,8 +2,11 @@ package service
 
 import (
 	"context"
+	"log"
 
 	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/mail"
+	"yp-diploma/internal/app/oidc"
 	"yp-diploma/internal/app/repository"
 )
 
@@ -12,12 +15,31 @@ type Service struct {
 	conf      *config.Config
 	orderPool *jobPool
 	orderDisp *jobDispatcher
+	pwdPolicy *passwordPolicy
+	sessCache *sessCache
+	mail      mail.Sender
+	// nil, если вход через OpenID Connect не настроен
+	oidc *oidc.Client
 }
 
 func New(repo *repository.Repository, conf *config.Config) *Service {
 	s := &Service{}
 	s.repo = repo
 	s.conf = conf
+	policy, err := newPasswordPolicy(conf.PasswordMinLen, conf.PasswordBlocklist)
+	if err != nil {
+		log.Fatalf("error loading password blocklist: %v", err)
+	}
+	s.pwdPolicy = policy
+	s.sessCache = newSessCache(conf.SessionCacheSize, conf.SessionCacheTTL)
+	sender, err := mail.New(conf)
+	if err != nil {
+		log.Fatalf("error configuring mail sender: %v", err)
+	}
+	s.mail = sender
+	if conf.OIDCIssuer != "" {
+		s.oidc = oidc.New(conf.OIDCIssuer, conf.OIDCClientID, conf.OIDCClientSecret, conf.OIDCRedirectURL, conf.OIDCScopes)
+	}
 	s.orderPool = NewJobPool()
 	s.orderDisp = NewDispatcher(context.Background(), s.orderPool, s.GetAccrual, s.SaveResults)
 	return s
@@ -26,3 +48,9 @@ func New(repo *repository.Repository, conf *config.Config) *Service {
 func getUserIDFromCtx(ctx context.Context) string {
 	return ctx.Value(config.ContextKeyUserID).(string)
 }
+
+// у запросов с API-ключом сессии нет, возвращается пустая строка
+func getSessionIDFromCtx(ctx context.Context) string {
+	sessID, _ := ctx.Value(config.ContextKeySessionID).(string)
+	return sessID
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"yp-diploma/internal/app/model"
+)
+
+type sessCacheEntry struct {
+	key      model.SessKey
+	cachedAt time.Time
+}
+
+// sessCache - ограниченный по размеру LRU-кеш проверенных сессий. Запись живет не дольше ttl,
+// отозванные сессии удаляются из кеша по уведомлениям из БД.
+type sessCache struct {
+	size  int
+	ttl   time.Duration
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}
+
+func newSessCache(size int, ttl time.Duration) *sessCache {
+	return &sessCache{
+		size:  size,
+		ttl:   ttl,
+		items: make(map[string]*list.Element, size),
+		order: list.New(),
+	}
+}
+
+func (c *sessCache) Get(id string) (model.SessKey, bool) {
+	if c.size <= 0 {
+		return model.SessKey{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[id]
+	if !ok {
+		return model.SessKey{}, false
+	}
+	entry := el.Value.(*sessCacheEntry)
+	now := time.Now()
+	if now.Sub(entry.cachedAt) > c.ttl || now.After(entry.key.Expires) {
+		c.remove(el)
+		return model.SessKey{}, false
+	}
+	c.order.MoveToFront(el)
+	return entry.key, true
+}
+
+func (c *sessCache) Put(key model.SessKey) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key.ID]; ok {
+		el.Value = &sessCacheEntry{key: key, cachedAt: time.Now()}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key.ID] = c.order.PushFront(&sessCacheEntry{key: key, cachedAt: time.Now()})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Invalidate обрабатывает уведомление об отзыве вида "<вид>:<id>".
+func (c *sessCache) Invalidate(payload string) {
+	kind, id, ok := strings.Cut(payload, ":")
+	if !ok {
+		log.Printf("unsupported revocation message: %s", payload)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if kind == model.RevokeSession {
+		if el, ok := c.items[id]; ok {
+			c.remove(el)
+		}
+		return
+	}
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		key := el.Value.(*sessCacheEntry).key
+		if (kind == model.RevokeFamily && key.FamilyID == id) || (kind == model.RevokeUser && key.UserID == id) {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+func (c *sessCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[string]*list.Element, c.size)
+	c.order.Init()
+}
+
+func (c *sessCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*sessCacheEntry).key.ID)
+}
+
+// ListenRevocations держит подписку на уведомления об отзыве сессий, при обрыве
+// соединения кеш сбрасывается (уведомления могли быть пропущены) и подписка восстанавливается.
+func (s *Service) ListenRevocations(ctx context.Context) {
+	if s.conf.SessionCacheSize <= 0 {
+		return
+	}
+	go func() {
+		for {
+			err := s.repo.ListenRevocations(ctx, s.sessCache.Invalidate)
+			s.sessCache.Flush()
+			if ctx.Err() != nil {
+				log.Println("revocation listener stop")
+				return
+			}
+			log.Printf("revocation listener error: %v, reconnecting", err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+)
+
+// количество проводок, читаемых за один запрос при построении выписки
+const statementBatch = 1000
+
+// GetBalanceAt возвращает баланс текущего пользователя на момент at.
+func (s *Service) GetBalanceAt(ctx context.Context, at time.Time) (model.Balance, error) {
+	return s.repo.GetBalanceAt(ctx, getUserIDFromCtx(ctx), at)
+}
+
+// GetStatement строит выписку текущего пользователя за календарный месяц month
+// (любой момент месяца) по времени сервера. Выписка за текущий месяц заканчивается текущим моментом.
+func (s *Service) GetStatement(ctx context.Context, month time.Time) (model.Statement, error) {
+	now := time.Now()
+	month = month.In(time.Local)
+	res := model.Statement{
+		UserID: getUserIDFromCtx(ctx),
+		From:   time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.Local),
+	}
+	if res.From.After(now) {
+		return model.Statement{}, config.ErrInvalidData
+	}
+	res.To = res.From.AddDate(0, 1, 0)
+
+	// баланс на начало - после последней проводки до начала месяца
+	opening, err := s.repo.GetBalanceAt(ctx, res.UserID, res.From.Add(-time.Microsecond))
+	if err != nil {
+		return model.Statement{}, err
+	}
+	res.Opening = opening.Balance
+	res.Closing = opening.Balance
+
+	q := model.LedgerQuery{UserID: res.UserID, From: res.From, To: res.To, Limit: statementBatch}
+	for {
+		entries, err := s.repo.GetLedgerEntries(ctx, q)
+		if err != nil {
+			return model.Statement{}, err
+		}
+		for _, e := range entries {
+			delta := e.Delta()
+			if delta > 0 {
+				res.Credits += delta
+			} else {
+				res.Debits -= delta
+			}
+			res.Closing = e.Balance
+		}
+		res.Entries = append(res.Entries, entries...)
+		if len(entries) < statementBatch {
+			break
+		}
+		last := entries[len(entries)-1]
+		q.AfterID = last.ID
+	}
+	return res, nil
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"strings"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+	"yp-diploma/internal/app/util"
+)
+
+// EnrollTOTP начинает подключение второго фактора: выдает секрет, ссылку otpauth://
+// и коды восстановления. Фактор начинает действовать только после ConfirmTOTP.
+func (s *Service) EnrollTOTP(ctx context.Context) (model.TOTPEnrollment, error) {
+	userID := getUserIDFromCtx(ctx)
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+	secret, err := util.GenTOTPSecret()
+	if err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+	sealed, err := util.SealBytes([]byte(secret), []byte(userID))
+	if err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+
+	codes := make([]string, config.TOTPRecoveryCodes)
+	hashes := make([]string, config.TOTPRecoveryCodes)
+	for i := range codes {
+		code, err := util.GetRandHexString(5)
+		if err != nil {
+			return model.TOTPEnrollment{}, err
+		}
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+
+	totp := model.TOTP{
+		UserID: userID,
+		Secret: base64.StdEncoding.EncodeToString(sealed),
+	}
+	err = s.repo.AddTOTP(ctx, totp, hashes)
+	if err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+	return model.TOTPEnrollment{
+		Secret:        secret,
+		URI:           util.OTPAuthURI(s.conf.TOTPIssuer, user.Name, secret),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// ConfirmTOTP включает второй фактор после проверки первого кода из приложения.
+func (s *Service) ConfirmTOTP(ctx context.Context, code string) error {
+	userID := getUserIDFromCtx(ctx)
+	totp, err := s.repo.GetTOTP(ctx, userID)
+	if err != nil {
+		if err == config.ErrNoSuchRecord {
+			return config.ErrTOTPNotEnrolled
+		}
+		return err
+	}
+	if totp.Confirmed {
+		return config.ErrTOTPEnabled
+	}
+	secret, err := openTOTPSecret(totp)
+	if err != nil {
+		return err
+	}
+	step, ok := util.MatchTOTP(secret, normalizeOTP(code), time.Now())
+	if !ok {
+		return config.ErrInvalidOTP
+	}
+	return s.repo.ConfirmTOTP(ctx, userID, step)
+}
+
+// DisableTOTP отключает второй фактор, требуется пароль (если он задан) и действующий код.
+// Неверные пароль и код учитываются как неудачные попытки входа.
+func (s *Service) DisableTOTP(ctx context.Context, password, code, ip string) error {
+	userID := getUserIDFromCtx(ctx)
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = s.confirmPasswd(ctx, user, password, ip)
+	if err != nil {
+		return err
+	}
+	err = s.confirmSecondFactor(ctx, user, code, ip)
+	if err != nil {
+		return err
+	}
+	return s.repo.DeleteTOTP(ctx, userID)
+}
+
+// LoginSecondFactor завершает вход пользователя с включенным вторым фактором.
+// mfaToken выдается LoginUser после проверки пароля, code - код из приложения или код восстановления.
+func (s *Service) LoginSecondFactor(ctx context.Context, mfaToken, code, ip string) (model.AuthTokens, error) {
+	ch, err := s.repo.UseLoginChallenge(ctx, hashToken(mfaToken))
+	if err != nil {
+		if err == config.ErrNoSuchRecord {
+			return model.AuthTokens{}, config.ErrInvalidToken
+		}
+		return model.AuthTokens{}, err
+	}
+	if time.Now().After(ch.Expires) || ch.Attempts > config.LoginChallengeAttempts {
+		err = s.repo.DeleteLoginChallenge(ctx, ch.ID)
+		if err != nil {
+			log.Printf("error deleting login challenge: %v", err)
+		}
+		return model.AuthTokens{}, config.ErrInvalidToken
+	}
+
+	user, err := s.repo.GetUserByID(ctx, ch.UserID)
+	if err != nil {
+		return model.AuthTokens{}, err
+	}
+	if user.Locked {
+		return model.AuthTokens{}, config.ErrUserLocked
+	}
+	err = s.checkLoginAllowed(ctx, attemptKeyUser(user.Name), attemptKeyIP(ip))
+	if err != nil {
+		return model.AuthTokens{}, err
+	}
+	err = s.checkSecondFactor(ctx, user.ID, code)
+	if err != nil {
+		if err == config.ErrInvalidOTP {
+			s.registerLoginFailure(ctx, user.Name, ip)
+		}
+		return model.AuthTokens{}, err
+	}
+
+	err = s.repo.DeleteLoginChallenge(ctx, ch.ID)
+	if err != nil {
+		return model.AuthTokens{}, err
+	}
+	err = s.repo.ResetLoginAttempts(ctx, attemptKeyUser(user.Name))
+	if err != nil {
+		log.Printf("error resetting login attempts for %s: %v", user.Name, err)
+	}
+	return s.genSessKey(ctx, user.ID, newFamilyID())
+}
+
+// startSecondFactor создает запрос входа, если у пользователя включен второй фактор.
+// Возвращает ErrSecondFactorRequired и токен для второго шага в AuthTokens.MFAToken.
+func (s *Service) startSecondFactor(ctx context.Context, userID string) (model.AuthTokens, error) {
+	totp, err := s.repo.GetTOTP(ctx, userID)
+	switch {
+	case err == config.ErrNoSuchRecord, err == nil && !totp.Confirmed:
+		return model.AuthTokens{}, nil
+	case err != nil:
+		return model.AuthTokens{}, err
+	}
+
+	mfaToken, err := util.GetRandHexString(32)
+	if err != nil {
+		return model.AuthTokens{}, err
+	}
+	ch := model.LoginChallenge{
+		ID:      hashToken(mfaToken),
+		UserID:  userID,
+		Expires: time.Now().Add(config.LoginChallengeDuration),
+	}
+	err = s.repo.AddLoginChallenge(ctx, ch)
+	if err != nil {
+		return model.AuthTokens{}, err
+	}
+	return model.AuthTokens{MFAToken: mfaToken}, config.ErrSecondFactorRequired
+}
+
+// checkSecondFactor принимает код из приложения (каждый не более одного раза)
+// или неиспользованный код восстановления.
+func (s *Service) checkSecondFactor(ctx context.Context, userID, code string) error {
+	totp, err := s.repo.GetTOTP(ctx, userID)
+	if err != nil {
+		if err == config.ErrNoSuchRecord {
+			return config.ErrTOTPNotEnrolled
+		}
+		return err
+	}
+	if !totp.Confirmed {
+		return config.ErrTOTPNotEnrolled
+	}
+	code = normalizeOTP(code)
+
+	var ok bool
+	if len(code) == util.TOTPDigits {
+		secret, err := openTOTPSecret(totp)
+		if err != nil {
+			return err
+		}
+		step, match := util.MatchTOTP(secret, code, time.Now())
+		if match {
+			ok, err = s.repo.UseTOTPStep(ctx, userID, step)
+		}
+	} else {
+		ok, err = s.repo.UseRecoveryCode(ctx, userID, hashToken(code))
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return config.ErrInvalidOTP
+	}
+	return nil
+}
+
+func openTOTPSecret(totp model.TOTP) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(totp.Secret)
+	if err != nil {
+		return "", err
+	}
+	secret, err := util.OpenBytes(sealed, []byte(totp.UserID))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// коды восстановления выдаются в виде "xxxxx-xxxxx", принимаются в любом регистре и без дефиса
+func normalizeOTP(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
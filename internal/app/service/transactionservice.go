@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+	"yp-diploma/internal/app/util"
+)
+
+// размер страницы истории операций по умолчанию и максимальный
+const (
+	transactionsLimit    = 50
+	transactionsMaxLimit = 500
+)
+
+// GetTransactions возвращает страницу истории операций текущего пользователя из журнала баллов
+// начиная с позиции cursor ("" - с начала).
+func (s *Service) GetTransactions(ctx context.Context, q model.LedgerQuery, cursor string) (model.LedgerPage, error) {
+	q.UserID = getUserIDFromCtx(ctx)
+	for _, kind := range q.Kinds {
+		if !validLedgerKind(kind) {
+			return model.LedgerPage{}, config.ErrInvalidData
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return model.LedgerPage{}, config.ErrInvalidData
+	}
+	switch {
+	case q.Limit == 0:
+		q.Limit = transactionsLimit
+	case q.Limit < 0 || q.Limit > transactionsMaxLimit:
+		return model.LedgerPage{}, config.ErrInvalidData
+	}
+	if cursor != "" {
+		var err error
+		q.AfterID, err = util.DecodeCursor(cursor)
+		if err != nil {
+			return model.LedgerPage{}, err
+		}
+	}
+
+	// лишняя запись показывает, что есть следующая страница
+	limit := q.Limit
+	q.Limit++
+	entries, err := s.repo.GetLedgerEntries(ctx, q)
+	if err != nil {
+		return model.LedgerPage{}, err
+	}
+	res := model.LedgerPage{Entries: entries}
+	if len(entries) > limit {
+		res.Entries = entries[:limit]
+		last := res.Entries[limit-1]
+		res.Next = util.EncodeCursor(last.ID)
+	}
+	return res, nil
+}
+
+func validLedgerKind(kind string) bool {
+	for _, k := range model.LedgerKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+)
+
+// Transfer переводит amount баллов текущего пользователя пользователю с логином recipient.
+// Сумма переводов одного пользователя за последние сутки ограничена TransferDailyLimit.
+func (s *Service) Transfer(ctx context.Context, recipient string, amount int) (model.Transfer, error) {
+	userID := getUserIDFromCtx(ctx)
+	to, err := s.repo.GetUserID(ctx, recipient)
+	if err != nil {
+		return model.Transfer{}, err
+	}
+	// удаленные учетные записи заблокированы и переводы не принимают
+	if to.Locked {
+		return model.Transfer{}, config.ErrNoSuchRecord
+	}
+	if to.ID == userID {
+		return model.Transfer{}, config.ErrTransferToSelf
+	}
+	now := time.Now()
+	t := model.Transfer{
+		SenderID:     userID,
+		RecipientID:  to.ID,
+		Counterparty: to.Name,
+		Amount:       amount,
+		GenTime:      now,
+	}
+	err = s.repo.AddTransfer(ctx, &t, now.Add(-24*time.Hour), model.WholePoints(s.conf.TransferDailyLimit))
+	if err != nil {
+		return model.Transfer{}, err
+	}
+	return t, nil
+}
+
+func (s *Service) GetTransfers(ctx context.Context) ([]model.Transfer, error) {
+	return s.repo.GetUserTransfers(ctx, getUserIDFromCtx(ctx))
+}
@@ -5,6 +5,8 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 	"yp-diploma/internal/app/config"
 	"yp-diploma/internal/app/model"
@@ -13,69 +15,285 @@ import (
 	"github.com/google/uuid"
 )
 
-func (s *Service) RegisterUser(ctx context.Context, name, password string) (string, error) {
-	pwdHash := sha256.Sum256([]byte(password))
+func (s *Service) RegisterUser(ctx context.Context, name, password string) (model.AuthTokens, error) {
+	err := s.pwdPolicy.Check(password)
+	if err != nil {
+		return model.AuthTokens{}, err
+	}
 	user := model.User{
 		ID:           uuid.New().String(),
 		Name:         name,
-		HashedPasswd: hex.EncodeToString(pwdHash[:]),
+		HashedPasswd: hashPasswd(password),
 	}
-	err := s.repo.AddUser(ctx, user)
+	err = s.repo.AddUser(ctx, user)
 	if err != nil {
-		return "", err
+		return model.AuthTokens{}, err
 	}
 
-	cryptKey := s.genSessKey(ctx, user)
-	if cryptKey == "" {
-		return "", fmt.Errorf("error creating session key for user: %s", user.Name)
+	tokens, err := s.genSessKey(ctx, user.ID, newFamilyID())
+	if err != nil {
+		return model.AuthTokens{}, fmt.Errorf("error creating session key for user: %s, %w", user.Name, err)
 	}
-	return cryptKey, nil
+	return tokens, nil
 }
 
-func (s *Service) LoginUser(ctx context.Context, name, password string) (string, error) {
+func (s *Service) LoginUser(ctx context.Context, name, password, ip string) (model.AuthTokens, error) {
+	err := s.checkLoginAllowed(ctx, attemptKeyUser(name), attemptKeyIP(ip))
+	if err != nil {
+		return model.AuthTokens{}, err
+	}
+
 	user, err := s.repo.GetUserID(ctx, name)
 	if err != nil {
-		return "", err
+		if err == config.ErrNoSuchRecord {
+			s.registerLoginFailure(ctx, name, ip)
+		}
+		return model.AuthTokens{}, err
 	}
 
 	if !CheckPasswd(password, user.HashedPasswd) {
-		return "", config.ErrUserInvalidPassword
+		s.registerLoginFailure(ctx, name, ip)
+		return model.AuthTokens{}, config.ErrUserInvalidPassword
+	}
+	if user.Locked {
+		return model.AuthTokens{}, config.ErrUserLocked
+	}
+	// при включенном втором факторе счетчик неудач сбрасывается только после второго шага
+	mfa, err := s.startSecondFactor(ctx, user.ID)
+	if err != nil {
+		return mfa, err
+	}
+	err = s.repo.ResetLoginAttempts(ctx, attemptKeyUser(name))
+	if err != nil {
+		log.Printf("error resetting login attempts for %s: %v", name, err)
+	}
+
+	tokens, err := s.genSessKey(ctx, user.ID, newFamilyID())
+	if err != nil {
+		return model.AuthTokens{}, fmt.Errorf("error creating session key for user: %s, %w", user.Name, err)
+	}
+	return tokens, nil
+}
+
+// RefreshSession обменивает refresh-токен на новую пару токенов.
+// Использованный refresh-токен погашается, повторное его предъявление
+// считается признаком кражи и отзывает все сессии семейства.
+func (s *Service) RefreshSession(ctx context.Context, refreshToken string) (model.AuthTokens, error) {
+	var tokens model.AuthTokens
+	// погашение токена, замена сессии семейства и выдача новых токенов выполняются
+	// в одной транзакции: при ошибке токен остается действующим и запрос можно повторить
+	old, err := s.repo.RotateRefreshToken(ctx, hashToken(refreshToken), func(old model.RefreshToken) (model.SessKey, model.RefreshToken, error) {
+		if time.Now().After(old.Expires) {
+			return model.SessKey{}, model.RefreshToken{}, config.ErrTokenExpired
+		}
+		user, err := s.repo.GetUserByID(ctx, old.UserID)
+		if err != nil {
+			return model.SessKey{}, model.RefreshToken{}, err
+		}
+		if user.Locked {
+			return model.SessKey{}, model.RefreshToken{}, config.ErrUserLocked
+		}
+		key, rt, res, err := s.newSessTokens(old.UserID, old.FamilyID)
+		tokens = res
+		return key, rt, err
+	})
+	if err != nil {
+		if err == config.ErrRefreshTokenReused {
+			log.Printf("refresh token reuse detected, family %s revoked", old.FamilyID)
+		}
+		return model.AuthTokens{}, err
 	}
+	s.sessCache.Invalidate(model.RevokeFamily + ":" + old.FamilyID)
+	return tokens, nil
+}
 
-	cryptKey := s.genSessKey(ctx, user)
-	if cryptKey == "" {
-		return "", fmt.Errorf("error creating session key for user: %s", user.Name)
+// genSessKey создает сессию и refresh-токен в семействе familyID.
+// Сессия живет AccessTokenTTL с момента последнего обращения,
+// но не дольше AccessTokenMaxAge - этот срок зашит в сам токен.
+func (s *Service) genSessKey(ctx context.Context, userID, familyID string) (model.AuthTokens, error) {
+	key, rt, tokens, err := s.newSessTokens(userID, familyID)
+	if err != nil {
+		return model.AuthTokens{}, err
+	}
+	err = s.repo.AddSessKey(ctx, key)
+	if err != nil {
+		return model.AuthTokens{}, err
 	}
-	return cryptKey, nil
+	err = s.repo.AddRefreshToken(ctx, rt)
+	if err != nil {
+		return model.AuthTokens{}, err
+	}
+	return tokens, nil
 }
 
-func (s *Service) genSessKey(ctx context.Context, user model.User) string {
+// newSessTokens готовит сессию и refresh-токен для сохранения в БД и токены для клиента.
+func (s *Service) newSessTokens(userID, familyID string) (model.SessKey, model.RefreshToken, model.AuthTokens, error) {
 	sessKey, err := util.GetRandHexString(16)
 	if err != nil {
-		return ""
+		return model.SessKey{}, model.RefreshToken{}, model.AuthTokens{}, err
 	}
+	now := time.Now()
 	key := model.SessKey{
-		ID:      sessKey,
-		UserID:  user.ID,
-		Expires: time.Now().Add(config.SessionKeyDuration),
+		ID:         sessKey,
+		UserID:     userID,
+		FamilyID:   familyID,
+		Expires:    now.Add(s.conf.AccessTokenTTL),
+		MaxExpires: now.Add(s.conf.AccessTokenMaxAge),
 	}
-	err = s.repo.AddSessKey(ctx, key)
+	cryptKey, err := util.EncodeToken(model.SessToken{SessionID: key.ID, Expires: key.MaxExpires})
 	if err != nil {
-		return ""
+		return model.SessKey{}, model.RefreshToken{}, model.AuthTokens{}, err
 	}
-	cryptKey, _ := util.EncodeString(util.SignString(sessKey))
-	return cryptKey
+
+	refresh, err := util.GetRandHexString(32)
+	if err != nil {
+		return model.SessKey{}, model.RefreshToken{}, model.AuthTokens{}, err
+	}
+	rt := model.RefreshToken{
+		ID:       hashToken(refresh),
+		FamilyID: familyID,
+		UserID:   userID,
+		Expires:  now.Add(s.conf.RefreshTokenTTL),
+	}
+	return key, rt, model.AuthTokens{
+		Access:         cryptKey,
+		AccessExpires:  key.MaxExpires,
+		Refresh:        refresh,
+		RefreshExpires: rt.Expires,
+	}, nil
 }
 
 func (s *Service) VerifySessionKey(ctx context.Context, sessionKey string) (string, error) {
+	if key, ok := s.sessCache.Get(sessionKey); ok {
+		return key.UserID, nil
+	}
 	key, err := s.repo.GetSessKey(ctx, sessionKey)
-	if err != nil || time.Now().After(key.Expires) {
+	if err != nil {
+		return "", err
+	}
+	if time.Now().After(key.Expires) {
+		return "", config.ErrTokenExpired
+	}
+	// блокировка отзывает сессии, но не полагается только на это: сессия,
+	// пережившая отзыв, у заблокированного пользователя не принимается
+	user, err := s.repo.GetUserByID(ctx, key.UserID)
+	if err != nil {
 		return "", err
 	}
+	if user.Locked {
+		return "", config.ErrUserLocked
+	}
+	// скользящее продление: активная сессия продлевается, когда прошла половина срока
+	if time.Until(key.Expires) < s.conf.AccessTokenTTL/2 {
+		newExpires := time.Now().Add(s.conf.AccessTokenTTL)
+		err = s.repo.TouchSessKey(ctx, key.ID, newExpires)
+		if err != nil {
+			log.Printf("error extending session %s: %v", key.ID, err)
+		} else if newExpires.Before(key.MaxExpires) {
+			key.Expires = newExpires
+		} else {
+			key.Expires = key.MaxExpires
+		}
+	}
+	s.sessCache.Put(key)
 	return key.UserID, nil
 }
 
+// LogoutUser отзывает текущую сессию вместе с ее refresh-токенами. Токен перестает приниматься
+// независимо от того, передавался он в cookie или в заголовке Authorization.
+func (s *Service) LogoutUser(ctx context.Context) error {
+	sessID := getSessionIDFromCtx(ctx)
+	key, err := s.repo.GetSessKey(ctx, sessID)
+	if err != nil {
+		return err
+	}
+	// локальный кеш сбрасывается сразу, остальные экземпляры - по уведомлению из БД
+	if key.FamilyID != "" {
+		err = s.repo.RevokeFamily(ctx, key.FamilyID)
+		s.sessCache.Invalidate(model.RevokeFamily + ":" + key.FamilyID)
+		return err
+	}
+	err = s.repo.DeleteSessKey(ctx, sessID)
+	s.sessCache.Invalidate(model.RevokeSession + ":" + sessID)
+	return err
+}
+
+// ChangePassword меняет пароль текущего пользователя. Все сессии, кроме текущей, отзываются.
+// Неверный текущий пароль учитывается как неудачная попытка входа, чтобы украденная сессия
+// не позволяла подбирать пароль в обход ограничений входа.
+func (s *Service) ChangePassword(ctx context.Context, current, password, ip string) error {
+	userID := getUserIDFromCtx(ctx)
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = s.checkLoginAllowed(ctx, attemptKeyUser(user.Name), attemptKeyIP(ip))
+	if err != nil {
+		return err
+	}
+	if !CheckPasswd(current, user.HashedPasswd) {
+		s.registerLoginFailure(ctx, user.Name, ip)
+		return config.ErrUserInvalidPassword
+	}
+	err = s.repo.ResetLoginAttempts(ctx, attemptKeyUser(user.Name))
+	if err != nil {
+		log.Printf("error resetting login attempts for %s: %v", user.Name, err)
+	}
+	err = s.pwdPolicy.Check(password)
+	if err != nil {
+		return err
+	}
+	err = s.repo.UpdatePasswd(ctx, userID, hashPasswd(password))
+	if err != nil {
+		return err
+	}
+
+	key, err := s.repo.GetSessKey(ctx, getSessionIDFromCtx(ctx))
+	if err != nil {
+		return err
+	}
+	err = s.repo.RevokeUserSessions(ctx, userID, key.FamilyID)
+	s.sessCache.Invalidate(model.RevokeUser + ":" + userID)
+	return err
+}
+
 func CheckPasswd(password, hash string) bool {
+	return hashPasswd(password) == hash
+}
+
+// hasPasswd - у пользователя есть пароль. Пользователи, созданные при входе через OIDC,
+// хранят пустой пароль (в столбце CHAR он читается пробелами), войти с паролем они не могут.
+func hasPasswd(user model.User) bool {
+	return strings.TrimSpace(user.HashedPasswd) != ""
+}
+
+func hashPasswd(password string) string {
 	pwdHash := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(pwdHash[:]) == hash
+	return hex.EncodeToString(pwdHash[:])
+}
+
+func newFamilyID() string {
+	return uuid.New().String()
+}
+
+// в БД хранятся только хеши refresh-токенов, токенов входа и кодов восстановления
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+const settingLegacyTokenUntil = "legacy_token_until"
+
+// LegacyTokenCutoff возвращает момент, после которого токены старого формата не принимаются:
+// LEGACY_TOKEN_UNTIL или LEGACY_TOKEN_GRACE от первого запуска с этой БД. Перезапуск срок не продлевает.
+func (s *Service) LegacyTokenCutoff(ctx context.Context) (time.Time, error) {
+	if !s.conf.LegacyTokenUntil.IsZero() {
+		return s.conf.LegacyTokenUntil, nil
+	}
+	until := time.Now().Add(s.conf.LegacyTokenGrace).UTC().Format(time.RFC3339)
+	stored, err := s.repo.InitSetting(ctx, settingLegacyTokenUntil, until)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, stored)
 }
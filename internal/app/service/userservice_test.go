@@ -0,0 +1,30 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"yp-diploma/internal/app/model"
+)
+
+func TestHasPasswd(t *testing.T) {
+	tests := []struct {
+		name   string
+		passwd string
+		want   bool
+	}{
+		{"password", hashPasswd("secret"), true},
+		{"oidc user", "", false},
+		{"padded by CHAR column", strings.Repeat(" ", 64), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasPasswd(model.User{HashedPasswd: tt.passwd}); got != tt.want {
+				t.Errorf("hasPasswd(%q) = %v, want %v", tt.passwd, got, tt.want)
+			}
+		})
+	}
+	// пустой пароль не должен подходить к учетной записи без пароля
+	if CheckPasswd("", strings.Repeat(" ", 64)) {
+		t.Error("empty password matches an account without password")
+	}
+}
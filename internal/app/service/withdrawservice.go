@@ -2,15 +2,12 @@ package service
 
 import (
 	"context"
-	"sync"
 	"time"
 	"yp-diploma/internal/app/config"
 	"yp-diploma/internal/app/model"
 	"yp-diploma/internal/app/util"
 )
 
-var mu sync.Mutex
-
 func (s *Service) NewWithdraw(ctx context.Context, ws model.Withdraw) error {
 	userID := getUserIDFromCtx(ctx)
 	ws.UserID = userID
@@ -20,29 +17,79 @@ func (s *Service) NewWithdraw(ctx context.Context, ws model.Withdraw) error {
 		return config.ErrLuhnCheckFailed
 	}
 
-	mu.Lock()
-	defer mu.Unlock()
-
-	bal, _ := s.repo.GetBalance(ctx, userID)
-	if bal.Balance < ws.Withdraw {
-		return config.ErrNotEnoughAccruals
-	}
-
+	// баланс проверяется в той же транзакции, что и запись списания
 	return s.repo.AddWithdraw(ctx, ws)
 }
 
+// CancelWithdraw отменяет списание текущего пользователя, если с него прошло
+// не больше WithdrawCancelWindow. Баллы возвращаются на баланс.
+func (s *Service) CancelWithdraw(ctx context.Context, orderID string) error {
+	userID := getUserIDFromCtx(ctx)
+	_, err := s.repo.ReverseWithdraw(ctx, orderID, model.WithdrawCancelled, func(w model.Withdraw) error {
+		if w.UserID != userID {
+			return config.ErrNoSuchRecord
+		}
+		if time.Since(w.GenTime) > s.conf.WithdrawCancelWindow {
+			return config.ErrCancelWindowExpired
+		}
+		return nil
+	})
+	return err
+}
+
 func (s *Service) GetWithdrawList(ctx context.Context) ([]model.Withdraw, error) {
 	userID := getUserIDFromCtx(ctx)
 	return s.repo.GetWithdrawList(ctx, userID)
 }
 
 func (s *Service) GetBalance(ctx context.Context) (model.Balance, error) {
-	userID := getUserIDFromCtx(ctx)
+	return s.userBalance(ctx, getUserIDFromCtx(ctx))
+}
+
+func (s *Service) userBalance(ctx context.Context, userID string) (model.Balance, error) {
 	bal, err := s.repo.GetBalance(ctx, userID)
 	switch err {
-	case nil, config.ErrNoSuchRecord:
+	case nil:
+	case config.ErrNoSuchRecord:
 		return bal, nil
 	default:
 		return model.Balance{}, err
 	}
+	if s.conf.PointsTTL > 0 && bal.Balance > 0 {
+		// сгорят баллы, начисленные раньше, чем PointsTTL до конца окна уведомления
+		bal.ExpiringBefore = time.Now().Add(s.conf.PointsExpiryNotice)
+		bal.Expiring, err = s.repo.ExpirablePoints(ctx, userID, bal.ExpiringBefore.Add(-s.conf.PointsTTL))
+		if err != nil {
+			return model.Balance{}, err
+		}
+	}
+	return bal, nil
+}
+
+// количество пользователей, обрабатываемых заданием сгорания за один запрос к БД
+const expiryBatch = 100
+
+// expirePoints списывает баллы, начисленные раньше PointsTTL назад и не израсходованные
+// (списания расходуют самые старые баллы).
+func (s *Service) expirePoints(ctx context.Context) (int64, error) {
+	var total int64
+	cutoff := time.Now().Add(-s.conf.PointsTTL)
+	for {
+		users, err := s.repo.GetUsersWithExpiredPoints(ctx, cutoff, expiryBatch)
+		if err != nil {
+			return total, err
+		}
+		for _, userID := range users {
+			n, err := s.repo.ExpirePoints(ctx, userID, cutoff)
+			if err != nil {
+				return total, err
+			}
+			if n > 0 {
+				total++
+			}
+		}
+		if len(users) < expiryBatch {
+			return total, nil
+		}
+	}
 }
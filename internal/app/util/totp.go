@@ -0,0 +1,76 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// параметры TOTP по RFC 6238, совместимые с распространенными приложениями-аутентификаторами
+const (
+	TOTPDigits = 6
+	totpPeriod = 30
+	totpSkew   = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// TOTPCode вычисляет код для временного шага step (количество периодов с начала эпохи).
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	hm := hmac.New(sha1.New, key)
+	hm.Write(msg)
+	sum := hm.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTPDigits, code%1000000), nil
+}
+
+// MatchTOTP проверяет код с допуском в один период в обе стороны
+// и возвращает шаг, которому код соответствует.
+func MatchTOTP(secret, code string, t time.Time) (int64, bool) {
+	step := t.Unix() / totpPeriod
+	for d := int64(-totpSkew); d <= totpSkew; d++ {
+		expected, err := TOTPCode(secret, step+d)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step + d, true
+		}
+	}
+	return 0, false
+}
+
+// OTPAuthURI - ссылка для добавления секрета в приложение-аутентификатор (обычно в виде QR-кода).
+func OTPAuthURI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(TOTPDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
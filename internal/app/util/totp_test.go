@@ -0,0 +1,73 @@
+package util
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// контрольные значения RFC 6238 (SHA1), усеченные до шести цифр
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatalf("TOTPCode: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode(t=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPCodeInvalidSecret(t *testing.T) {
+	_, err := TOTPCode("not base32!", 1)
+	if err == nil {
+		t.Error("TOTPCode accepted an invalid secret")
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret, err := GenTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenTOTPSecret: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	step := now.Unix() / totpPeriod
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"current", 0, true},
+		{"previous", -1, true},
+		{"next", 1, true},
+		{"too old", -2, false},
+		{"too new", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := TOTPCode(secret, step+tt.offset)
+			if err != nil {
+				t.Fatalf("TOTPCode: %v", err)
+			}
+			got, ok := MatchTOTP(secret, code, now)
+			if ok != tt.ok {
+				t.Fatalf("MatchTOTP ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && got != step+tt.offset {
+				t.Errorf("MatchTOTP step = %d, want %d", got, step+tt.offset)
+			}
+		})
+	}
+}
@@ -7,11 +7,22 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"strconv"
+	"strings"
+	"time"
 	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
 )
 
+// TokenVersion - префикс текущего формата сессионного токена.
+const TokenVersion = "v2"
+
+const sessIDLen = 16
+
 func GetRandHexString(lenbyte int) (string, error) {
 	newID := make([]byte, lenbyte)
 	_, err := rand.Read(newID)
@@ -21,15 +32,6 @@ func GetRandHexString(lenbyte int) (string, error) {
 	return hex.EncodeToString(newID), nil
 }
 
-func SignString(msg string) string {
-	buf, _ := hex.DecodeString(msg)
-	key := sha256.Sum256([]byte(config.PassCiph))
-	hm := hmac.New(md5.New, key[:])
-	hm.Write(buf)
-	sign := hex.EncodeToString(hm.Sum(nil))
-	return sign + msg
-}
-
 func UnsignString(msg string) (string, bool) {
 	buf, err := hex.DecodeString(msg)
 	if err != nil {
@@ -72,27 +74,113 @@ func DecodeString(msg string) (string, error) {
 	return hex.EncodeToString(encbuf), nil
 }
 
-func EncodeString(msg string) (string, error) {
-	key := sha256.Sum256([]byte(config.PassCiph))
-	aesblock, err := aes.NewCipher(key[:])
+// SealBytes шифрует buf AES-GCM со случайным nonce. Nonce записывается перед шифротекстом,
+// ad аутентифицируется, но не шифруется.
+func SealBytes(buf, ad []byte) ([]byte, error) {
+	aesgcm, err := newAEAD()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	aesgcm, err := cipher.NewGCM(aesblock)
+	nonce := make([]byte, aesgcm.NonceSize())
+	_, err = rand.Read(nonce)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return aesgcm.Seal(nonce, nonce, buf, ad), nil
+}
 
-	nonce := key[len(key)-aesgcm.NonceSize():]
+func OpenBytes(buf, ad []byte) ([]byte, error) {
+	aesgcm, err := newAEAD()
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < aesgcm.NonceSize() {
+		return nil, config.ErrInvalidToken
+	}
+	nonce, encbuf := buf[:aesgcm.NonceSize()], buf[aesgcm.NonceSize():]
+	res, err := aesgcm.Open(nil, nonce, encbuf, ad)
+	if err != nil {
+		return nil, config.ErrInvalidToken
+	}
+	return res, nil
+}
 
-	buf, err := hex.DecodeString(msg)
+func newAEAD() (cipher.AEAD, error) {
+	// ключ отличается от ключа старого формата, чтобы токены разных версий не пересекались
+	key := sha256.Sum256([]byte(TokenVersion + ":" + config.PassCiph))
+	aesblock, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(aesblock)
+}
+
+// EncodeToken упаковывает идентификатор сессии и срок ее действия в токен вида "v2.<base64>".
+func EncodeToken(token model.SessToken) (string, error) {
+	sessID, err := hex.DecodeString(token.SessionID)
+	if err != nil || len(sessID) != sessIDLen {
+		return "", config.ErrInvalidData
+	}
+	buf := make([]byte, sessIDLen+8)
+	copy(buf, sessID)
+	binary.BigEndian.PutUint64(buf[sessIDLen:], uint64(token.Expires.Unix()))
+
+	encbuf, err := SealBytes(buf, []byte(TokenVersion))
 	if err != nil {
 		return "", err
 	}
+	return TokenVersion + "." + base64.RawURLEncoding.EncodeToString(encbuf), nil
+}
+
+func DecodeToken(msg string) (model.SessToken, error) {
+	prefix, body, ok := strings.Cut(msg, ".")
+	if !ok || prefix != TokenVersion {
+		return model.SessToken{}, config.ErrInvalidToken
+	}
+	encbuf, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return model.SessToken{}, config.ErrInvalidToken
+	}
+	buf, err := OpenBytes(encbuf, []byte(TokenVersion))
+	if err != nil || len(buf) != sessIDLen+8 {
+		return model.SessToken{}, config.ErrInvalidToken
+	}
+	res := model.SessToken{
+		SessionID: hex.EncodeToString(buf[:sessIDLen]),
+		Expires:   time.Unix(int64(binary.BigEndian.Uint64(buf[sessIDLen:])), 0),
+	}
+	if time.Now().After(res.Expires) {
+		return res, config.ErrTokenExpired
+	}
+	return res, nil
+}
 
-	encbuf := aesgcm.Seal(nil, nonce, buf, nil)
+// CSRFToken - токен для защиты от CSRF, производный от токена сессии. Передается браузеру
+// в доступной скрипту cookie и должен возвращаться в заголовке запроса.
+func CSRFToken(sessToken string) string {
+	key := sha256.Sum256([]byte("csrf:" + config.PassCiph))
+	hm := hmac.New(sha256.New, key[:])
+	hm.Write([]byte(sessToken))
+	return hex.EncodeToString(hm.Sum(nil))
+}
 
-	return hex.EncodeToString(encbuf), nil
+// IsLegacyToken - токен выдан до перехода на формат v2 (hex без префикса версии).
+func IsLegacyToken(msg string) bool {
+	return !strings.HasPrefix(msg, TokenVersion+".")
+}
+
+// DecodeLegacyToken разбирает токен старого формата (AES-GCM с постоянным nonce + HMAC-MD5).
+// Оставлен только для приема ранее выданных cookie в течение переходного периода.
+func DecodeLegacyToken(msg string) (string, error) {
+	signKey, err := DecodeString(msg)
+	if err != nil {
+		return "", config.ErrInvalidToken
+	}
+	sessKey, ok := UnsignString(signKey)
+	if !ok {
+		return "", config.ErrInvalidToken
+	}
+	return sessKey, nil
 }
 
 func LuhnCheck(num string) bool {
@@ -115,3 +203,122 @@ func LuhnCheck(num string) bool {
 	}
 	return sum%10 == 0
 }
+
+// ссылка подтверждения адреса и состояние входа OIDC шифруются тем же ключом, что и токен
+// сессии, но с другими дополнительными данными, поэтому один токен нельзя выдать за другой
+const (
+	emailTokenAD = "email"
+	oidcStateAD  = "oidc"
+)
+
+type emailTokenPayload struct {
+	UserID  string `json:"u"`
+	Email   string `json:"e"`
+	Expires int64  `json:"x"`
+}
+
+type oidcStatePayload struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Expires  int64  `json:"x"`
+}
+
+// EncodeEmailToken упаковывает пользователя и подтверждаемый адрес в токен для ссылки из письма.
+func EncodeEmailToken(token model.EmailToken) (string, error) {
+	return sealJSON(emailTokenPayload{
+		UserID:  token.UserID,
+		Email:   token.Email,
+		Expires: token.Expires.Unix(),
+	}, emailTokenAD)
+}
+
+func DecodeEmailToken(msg string) (model.EmailToken, error) {
+	payload := emailTokenPayload{}
+	err := openJSON(msg, emailTokenAD, &payload)
+	if err != nil {
+		return model.EmailToken{}, err
+	}
+	res := model.EmailToken{
+		UserID:  payload.UserID,
+		Email:   payload.Email,
+		Expires: time.Unix(payload.Expires, 0),
+	}
+	if time.Now().After(res.Expires) {
+		return res, config.ErrTokenExpired
+	}
+	return res, nil
+}
+
+// EncodeOIDCState упаковывает state, nonce и секрет PKCE входа OIDC для cookie браузера.
+func EncodeOIDCState(st model.OIDCState) (string, error) {
+	return sealJSON(oidcStatePayload{
+		State:    st.State,
+		Nonce:    st.Nonce,
+		Verifier: st.Verifier,
+		Expires:  st.Expires.Unix(),
+	}, oidcStateAD)
+}
+
+func DecodeOIDCState(msg string) (model.OIDCState, error) {
+	payload := oidcStatePayload{}
+	err := openJSON(msg, oidcStateAD, &payload)
+	if err != nil {
+		return model.OIDCState{}, err
+	}
+	res := model.OIDCState{
+		State:    payload.State,
+		Nonce:    payload.Nonce,
+		Verifier: payload.Verifier,
+		Expires:  time.Unix(payload.Expires, 0),
+	}
+	if time.Now().After(res.Expires) {
+		return res, config.ErrTokenExpired
+	}
+	return res, nil
+}
+
+func sealJSON(v any, ad string) (string, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	encbuf, err := SealBytes(buf, []byte(ad))
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(encbuf), nil
+}
+
+func openJSON(msg, ad string, v any) error {
+	encbuf, err := base64.RawURLEncoding.DecodeString(msg)
+	if err != nil {
+		return config.ErrInvalidToken
+	}
+	buf, err := OpenBytes(encbuf, []byte(ad))
+	if err != nil {
+		return config.ErrInvalidToken
+	}
+	err = json.Unmarshal(buf, v)
+	if err != nil {
+		return config.ErrInvalidToken
+	}
+	return nil
+}
+
+// EncodeCursor - курсор постраничной выдачи, указывающий на запись id.
+func EncodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func DecodeCursor(cursor string) (int64, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, config.ErrInvalidData
+	}
+	id, err := strconv.ParseInt(string(buf), 10, 64)
+	if err != nil || id < 0 {
+		return 0, config.ErrInvalidData
+	}
+	return id, nil
+}
@@ -0,0 +1,158 @@
+package util
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+	"yp-diploma/internal/app/config"
+	"yp-diploma/internal/app/model"
+)
+
+func TestSessToken(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name    string
+		token   model.SessToken
+		wantErr error
+	}{
+		{"valid", model.SessToken{SessionID: "00112233445566778899aabbccddeeff", Expires: expires}, nil},
+		{"expired", model.SessToken{SessionID: "00112233445566778899aabbccddeeff", Expires: time.Now().Add(-time.Hour)}, config.ErrTokenExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := EncodeToken(tt.token)
+			if err != nil {
+				t.Fatalf("EncodeToken: %v", err)
+			}
+			if IsLegacyToken(msg) {
+				t.Errorf("token %q is treated as legacy", msg)
+			}
+			got, err := DecodeToken(msg)
+			if err != tt.wantErr {
+				t.Fatalf("DecodeToken error = %v, want %v", err, tt.wantErr)
+			}
+			if got.SessionID != tt.token.SessionID || got.Expires.Unix() != tt.token.Expires.Unix() {
+				t.Errorf("DecodeToken = %+v, want %+v", got, tt.token)
+			}
+		})
+	}
+}
+
+func TestEncodeTokenInvalid(t *testing.T) {
+	for _, id := range []string{"", "xyz", "0011"} {
+		_, err := EncodeToken(model.SessToken{SessionID: id, Expires: time.Now()})
+		if err != config.ErrInvalidData {
+			t.Errorf("EncodeToken(%q) error = %v, want %v", id, err, config.ErrInvalidData)
+		}
+	}
+}
+
+func TestDecodeTokenInvalid(t *testing.T) {
+	valid, err := EncodeToken(model.SessToken{SessionID: "00112233445566778899aabbccddeeff", Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("EncodeToken: %v", err)
+	}
+	tampered := []byte(valid)
+	tampered[len(tampered)-1] ^= 1
+	tests := []struct {
+		name string
+		msg  string
+	}{
+		{"empty", ""},
+		{"no version", strings.TrimPrefix(valid, TokenVersion+".")},
+		{"wrong version", "v1." + strings.TrimPrefix(valid, TokenVersion+".")},
+		{"bad base64", TokenVersion + ".!!!"},
+		{"tampered", string(tampered)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeToken(tt.msg)
+			if err != config.ErrInvalidToken {
+				t.Errorf("DecodeToken error = %v, want %v", err, config.ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestEmailToken(t *testing.T) {
+	token := model.EmailToken{UserID: "user", Email: "user@example.com", Expires: time.Now().Add(time.Hour)}
+	msg, err := EncodeEmailToken(token)
+	if err != nil {
+		t.Fatalf("EncodeEmailToken: %v", err)
+	}
+	got, err := DecodeEmailToken(msg)
+	if err != nil {
+		t.Fatalf("DecodeEmailToken: %v", err)
+	}
+	if got.UserID != token.UserID || got.Email != token.Email || got.Expires.Unix() != token.Expires.Unix() {
+		t.Errorf("DecodeEmailToken = %+v, want %+v", got, token)
+	}
+
+	token.Expires = time.Now().Add(-time.Minute)
+	msg, err = EncodeEmailToken(token)
+	if err != nil {
+		t.Fatalf("EncodeEmailToken: %v", err)
+	}
+	_, err = DecodeEmailToken(msg)
+	if err != config.ErrTokenExpired {
+		t.Errorf("DecodeEmailToken(expired) error = %v, want %v", err, config.ErrTokenExpired)
+	}
+}
+
+func TestOIDCState(t *testing.T) {
+	st := model.OIDCState{State: "state", Nonce: "nonce", Verifier: "verifier", Expires: time.Now().Add(time.Minute)}
+	msg, err := EncodeOIDCState(st)
+	if err != nil {
+		t.Fatalf("EncodeOIDCState: %v", err)
+	}
+	got, err := DecodeOIDCState(msg)
+	if err != nil {
+		t.Fatalf("DecodeOIDCState: %v", err)
+	}
+	if got.State != st.State || got.Nonce != st.Nonce || got.Verifier != st.Verifier || got.Expires.Unix() != st.Expires.Unix() {
+		t.Errorf("DecodeOIDCState = %+v, want %+v", got, st)
+	}
+	// состояние входа нельзя выдать за токен письма и наоборот
+	_, err = DecodeEmailToken(msg)
+	if err != config.ErrInvalidToken {
+		t.Errorf("DecodeEmailToken(oidc state) error = %v, want %v", err, config.ErrInvalidToken)
+	}
+	email, err := EncodeEmailToken(model.EmailToken{UserID: "user", Email: "user@example.com", Expires: st.Expires})
+	if err != nil {
+		t.Fatalf("EncodeEmailToken: %v", err)
+	}
+	_, err = DecodeOIDCState(email)
+	if err != config.ErrInvalidToken {
+		t.Errorf("DecodeOIDCState(email token) error = %v, want %v", err, config.ErrInvalidToken)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	for _, id := range []int64{0, 1, 42, 1<<63 - 1} {
+		got, err := DecodeCursor(EncodeCursor(id))
+		if err != nil || got != id {
+			t.Errorf("DecodeCursor(EncodeCursor(%d)) = %d, %v", id, got, err)
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"bad base64", "!!!"},
+		{"not a number", base64.RawURLEncoding.EncodeToString([]byte("abc"))},
+		{"negative", base64.RawURLEncoding.EncodeToString([]byte("-1"))},
+		{"empty", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeCursor(tt.cursor)
+			if err != config.ErrInvalidData {
+				t.Errorf("DecodeCursor(%q) error = %v, want %v", tt.cursor, err, config.ErrInvalidData)
+			}
+		})
+	}
+}